@@ -6,4 +6,7 @@ const (
 	ChannelWorkerNode = "nodes:"
 
 	ChannelMasterNode = "nodes:master"
+
+	// 调度节点（持有调度租约的主节点）
+	ChannelSchedulerLeader = "nodes:scheduler-leader"
 )
@@ -3,3 +3,18 @@ package constants
 const (
 	ErrorRegexPattern = "(?:[ :,.]|^)((?:error|exception|traceback)s?)(?:[ :,.]|$)"
 )
+
+// 日志来源
+const (
+	LogStreamStdout = "stdout"
+	LogStreamStderr = "stderr"
+)
+
+// 日志级别，无法识别时为空
+const (
+	LogLevelDebug    = "debug"
+	LogLevelInfo     = "info"
+	LogLevelWarning  = "warning"
+	LogLevelError    = "error"
+	LogLevelCritical = "critical"
+)
@@ -6,4 +6,8 @@ const (
 	MsgTypeCancelTask    = "cancel-task"
 	MsgTypeRemoveLog     = "remove-log"
 	MsgTypeRemoveSpider  = "remove-spider"
+
+	// 调度节点重新加载定时任务
+	MsgTypeReloadSchedules = "reload-schedules"
+	MsgTypeReloadGitCron   = "reload-git-cron"
 )
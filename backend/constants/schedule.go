@@ -5,6 +5,36 @@ const (
 	ScheduleStatusRunning = "running"
 	ScheduleStatusError   = "error"
 
+	// 根据最近运行情况得出的状态
+	ScheduleStatusHealthy  = "healthy"  // 最近的运行基本成功
+	ScheduleStatusDegraded = "degraded" // 最近的运行部分失败
+	ScheduleStatusFailing  = "failing"  // 最近的运行连续失败
+
 	ScheduleStatusErrorNotFoundNode   = "Not Found Node"
 	ScheduleStatusErrorNotFoundSpider = "Not Found Spider"
 )
+
+const (
+	ScheduleTypeCron     = "cron"     // cron表达式
+	ScheduleTypeInterval = "interval" // 从开始时间起每隔固定时间运行
+	ScheduleTypeOnce     = "once"     // 在开始时间运行一次
+)
+
+const (
+	ScheduleOverlapAllow   = "allow"   // 允许重叠运行
+	ScheduleOverlapSkip    = "skip"    // 上一次运行未结束则跳过
+	ScheduleOverlapQueue   = "queue"   // 上一次运行结束后再运行（最多等待一次）
+	ScheduleOverlapReplace = "replace" // 取消上一次运行后再运行
+)
+
+const (
+	ScheduleMisfireIgnore  = "ignore"   // 忽略错过的触发
+	ScheduleMisfireRunOnce = "run_once" // 补跑一次
+	ScheduleMisfireRunAll  = "run_all"  // 补跑所有错过的触发（不超过最大次数）
+)
+
+const (
+	ScheduleFireTriggered = "triggered" // 已创建任务
+	ScheduleFireSkipped   = "skipped"   // 被排除日历或重叠策略跳过
+	ScheduleFireError     = "error"     // 创建任务出错
+)
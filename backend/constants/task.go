@@ -13,11 +13,14 @@ const (
 	StatusCancelled string = "cancelled"
 	// 节点重启导致的异常终止
 	StatusAbnormal string = "abnormal"
+	// 超时终止
+	StatusTimeout string = "timeout"
 )
 
 const (
-	TaskFinish string = "finish"
-	TaskCancel string = "cancel"
+	TaskFinish  string = "finish"
+	TaskCancel  string = "cancel"
+	TaskTimeout string = "timeout"
 )
 
 const (
@@ -26,7 +29,19 @@ const (
 	RunTypeSelectedNodes string = "selected-nodes"
 )
 
+const (
+	// 任务触发来源
+	TaskTriggerWebhook string = "webhook"
+)
+
 const (
 	TaskTypeSpider string = "spider"
 	TaskTypeSystem string = "system"
 )
+
+const (
+	// 任务优先级，数值越小优先级越高
+	TaskPriorityHighest int = 1
+	TaskPriorityDefault int = 5
+	TaskPriorityLowest  int = 10
+)
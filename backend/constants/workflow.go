@@ -0,0 +1,7 @@
+package constants
+
+const (
+	WorkflowConditionOnSuccess = "on-success"
+	WorkflowConditionOnFailure = "on-failure"
+	WorkflowConditionAlways    = "always"
+)
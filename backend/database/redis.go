@@ -56,6 +56,19 @@ func (r *Redis) LPush(collection string, value interface{}) error {
 	return nil
 }
 
+// 加入列表头部（用 LPOP/BLPOP 消费的队列中最先被取出）
+func (r *Redis) PushFront(collection string, value interface{}) error {
+	c := r.pool.Get()
+	defer utils.Close(c)
+
+	if _, err := c.Do("LPUSH", collection, value); err != nil {
+		log.Error(err.Error())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
 func (r *Redis) LPop(collection string) (string, error) {
 	c := r.pool.Get()
 	defer utils.Close(c)
@@ -67,6 +80,82 @@ func (r *Redis) LPop(collection string) (string, error) {
 	return value, nil
 }
 
+func (r *Redis) LRem(collection string, count int, value interface{}) (int, error) {
+	c := r.pool.Get()
+	defer utils.Close(c)
+
+	n, err := redis.Int(c.Do("LREM", collection, count, value))
+	if err != nil {
+		log.Error(err.Error())
+		debug.PrintStack()
+		return n, err
+	}
+	return n, nil
+}
+
+func (r *Redis) LLen(collection string) (int, error) {
+	c := r.pool.Get()
+	defer utils.Close(c)
+
+	n, err := redis.Int(c.Do("LLEN", collection))
+	if err != nil {
+		log.Error(err.Error())
+		debug.PrintStack()
+		return n, err
+	}
+	return n, nil
+}
+
+func (r *Redis) LRange(collection string, start int, stop int) ([]string, error) {
+	c := r.pool.Get()
+	defer utils.Close(c)
+
+	values, err := redis.Strings(c.Do("LRANGE", collection, start, stop))
+	if err != nil {
+		log.Error(err.Error())
+		debug.PrintStack()
+		return values, err
+	}
+	return values, nil
+}
+
+// 原子地弹出 source 的最后一个元素并加入 destination 的头部，source 为空时返回 redis.ErrNil
+func (r *Redis) RPopLPush(source string, destination string) (string, error) {
+	c := r.pool.Get()
+	defer utils.Close(c)
+
+	return redis.String(c.Do("RPOPLPUSH", source, destination))
+}
+
+// 批量加入列表，只保留最后 maxLen 个元素并设置过期时间
+func (r *Redis) RPushCapped(collection string, values []string, maxLen int, ttl time.Duration) error {
+	c := r.pool.Get()
+	defer utils.Close(c)
+
+	_ = c.Send("MULTI")
+	_ = c.Send("RPUSH", redis.Args{}.Add(collection).AddFlat(values)...)
+	_ = c.Send("LTRIM", collection, -maxLen, -1)
+	_ = c.Send("PEXPIRE", collection, ttl.Milliseconds())
+	if _, err := c.Do("EXEC"); err != nil {
+		log.Errorf("rpush capped error: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// key 不存在时设置值和过期时间，已存在时返回 false
+func (r *Redis) SetNX(key string, value string, ttl time.Duration) (bool, error) {
+	c := r.pool.Get()
+	defer utils.Close(c)
+
+	ok, err := c.Do("SET", key, value, "NX", "PX", ttl.Milliseconds())
+	if err != nil {
+		log.Errorf("set nx error: %s", err.Error())
+		return false, err
+	}
+	return ok != nil, nil
+}
+
 func (r *Redis) HSet(collection string, key string, value string) error {
 	c := r.pool.Get()
 	defer utils.Close(c)
@@ -163,6 +252,27 @@ func (r *Redis) BRPop(collection string, timeout int) (string, error) {
 	return values[1], nil
 }
 
+// 阻塞地从多个队列中按顺序弹出第一个元素，超时返回 redis.ErrNil
+func (r *Redis) BLPop(collections []string, timeout int) (string, string, error) {
+	if timeout <= 0 {
+		timeout = 60
+	}
+	c := r.pool.Get()
+	defer utils.Close(c)
+
+	args := make([]interface{}, 0, len(collections)+1)
+	for _, collection := range collections {
+		args = append(args, collection)
+	}
+	args = append(args, timeout)
+
+	values, err := redis.Strings(c.Do("BLPOP", args...))
+	if err != nil {
+		return "", "", err
+	}
+	return values[0], values[1], nil
+}
+
 func NewRedisPool() *redis.Pool {
 	var address = viper.GetString("redis.address")
 	var port = viper.GetString("redis.port")
@@ -252,7 +362,8 @@ func (r *Redis) Lock(lockKey string) (int64, error) {
 		return 0, err
 	}
 	if ok == nil {
-		log.Errorf("the lockKey is locked: key=%s", lockKey)
+		// 锁被占用属于正常竞争
+		log.Debugf("the lockKey is locked: key=%s", lockKey)
 		return 0, errors.New("the lockKey is locked")
 	}
 	return ts, nil
@@ -287,3 +398,62 @@ func (r *Redis) UnLock(lockKey string, value int64) {
 		return
 	}
 }
+
+// 续约脚本：租约仍属于当前持有者时延长过期时间
+var renewLeaseScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// 释放脚本：租约仍属于当前持有者时删除
+var releaseLeaseScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// 获得租约，租约已被持有时返回 false
+func (r *Redis) AcquireLease(leaseKey string, value string, ttl time.Duration) (bool, error) {
+	c := r.pool.Get()
+	defer utils.Close(c)
+
+	ok, err := c.Do("SET", r.getLockKey(leaseKey), value, "NX", "PX", ttl.Milliseconds())
+	if err != nil {
+		return false, err
+	}
+	return ok != nil, nil
+}
+
+// 续约，租约已过期或被其他持有者获得时返回 false
+func (r *Redis) RenewLease(leaseKey string, value string, ttl time.Duration) (bool, error) {
+	c := r.pool.Get()
+	defer utils.Close(c)
+
+	n, err := redis.Int(renewLeaseScript.Do(c, r.getLockKey(leaseKey), value, ttl.Milliseconds()))
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// 释放租约（仅当前持有者）
+func (r *Redis) ReleaseLease(leaseKey string, value string) error {
+	c := r.pool.Get()
+	defer utils.Close(c)
+
+	_, err := releaseLeaseScript.Do(c, r.getLockKey(leaseKey), value)
+	return err
+}
+
+// 获取租约持有者，没有持有者时返回空字符串
+func (r *Redis) GetLeaseHolder(leaseKey string) (string, error) {
+	c := r.pool.Get()
+	defer utils.Close(c)
+
+	value, err := redis.String(c.Do("GET", r.getLockKey(leaseKey)))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	return value, err
+}
@@ -2,6 +2,7 @@ package cron
 
 import (
 	"fmt"
+	"hash/fnv"
 	"math"
 	"strconv"
 	"strings"
@@ -47,6 +48,7 @@ var defaults = []string{
 // A custom Parser that can be configured.
 type Parser struct {
 	options ParseOption
+	hashKey string
 }
 
 // NewParser creates a Parser with custom options.
@@ -79,7 +81,26 @@ func NewParser(options ParseOption) Parser {
 	if optionals > 1 {
 		panic("multiple optionals may not be configured")
 	}
-	return Parser{options}
+	return Parser{options: options}
+}
+
+// WithHashKey returns a copy of the parser that resolves "H" fields using the
+// given key. The same spec yields stable, but different, times for different
+// keys, which spreads out schedules sharing a spec (e.g. "0 H H * * *").
+//
+// Supported forms for each field are "H", "H/step", "H(min-max)" and
+// "H(min-max)/step". An unbounded "H" in the day of month field is limited
+// to 1-28 so that it fires in every month.
+func (p Parser) WithHashKey(key string) Parser {
+	p.hashKey = key
+	return p
+}
+
+// hash returns the hash value used for the field at the given position.
+func (p Parser) hash(position int) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(p.hashKey + "/" + strconv.Itoa(position)))
+	return h.Sum64()
 }
 
 // Parse returns a new crontab schedule representing the given spec.
@@ -120,22 +141,22 @@ func (p Parser) Parse(spec string) (Schedule, error) {
 		return nil, err
 	}
 
-	field := func(field string, r bounds) uint64 {
+	field := func(position int, r bounds) uint64 {
 		if err != nil {
 			return 0
 		}
 		var bits uint64
-		bits, err = getField(field, r)
+		bits, err = getHashedField(fields[position], r, p.hash(position))
 		return bits
 	}
 
 	var (
-		second     = field(fields[0], seconds)
-		minute     = field(fields[1], minutes)
-		hour       = field(fields[2], hours)
-		dayofmonth = field(fields[3], dom)
-		month      = field(fields[4], months)
-		dayofweek  = field(fields[5], dow)
+		second     = field(0, seconds)
+		minute     = field(1, minutes)
+		hour       = field(2, hours)
+		dayofmonth = field(3, dom)
+		month      = field(4, months)
+		dayofweek  = field(5, dow)
 	)
 	if err != nil {
 		return nil, err
@@ -234,10 +255,24 @@ func ParseStandard(standardSpec string) (Schedule, error) {
 // the field represents or error parsing field value.  A "field" is a comma-separated
 // list of "ranges".
 func getField(field string, r bounds) (uint64, error) {
+	return getHashedField(field, r, 0)
+}
+
+// getHashedField is like getField, but also accepts "H" ranges, which are
+// resolved using the given hash value.
+func getHashedField(field string, r bounds, hash uint64) (uint64, error) {
 	var bits uint64
 	ranges := strings.FieldsFunc(field, func(r rune) bool { return r == ',' })
 	for _, expr := range ranges {
-		bit, err := getRange(expr, r)
+		var (
+			bit uint64
+			err error
+		)
+		if strings.HasPrefix(expr, "H") {
+			bit, err = getHashRange(expr, r, hash)
+		} else {
+			bit, err = getRange(expr, r)
+		}
 		if err != nil {
 			return bits, err
 		}
@@ -246,6 +281,70 @@ func getField(field string, r bounds) (uint64, error) {
 	return bits, nil
 }
 
+// getHashRange returns the bits indicated by the given hash expression:
+//   "H" [ "(" number "-" number ")" ] [ "/" number ]
+// A single value within the range is chosen by the hash; with a step, the
+// hash chooses the offset of the first value.
+func getHashRange(expr string, r bounds, hash uint64) (uint64, error) {
+	var (
+		err          error
+		low, high    = r.min, r.max
+		rangeAndStep = strings.Split(expr, "/")
+		h            = rangeAndStep[0]
+	)
+
+	if h == "H" {
+		// Days after the 28th do not exist in every month.
+		if r.min == dom.min && r.max == dom.max {
+			high = 28
+		}
+	} else {
+		if !strings.HasPrefix(h, "H(") || !strings.HasSuffix(h, ")") {
+			return 0, fmt.Errorf("invalid hash expression: %s", expr)
+		}
+		lowAndHigh := strings.Split(h[2:len(h)-1], "-")
+		if len(lowAndHigh) != 2 {
+			return 0, fmt.Errorf("hash range should be min-max: %s", expr)
+		}
+		if low, err = parseIntOrName(lowAndHigh[0], r.names); err != nil {
+			return 0, err
+		}
+		if high, err = parseIntOrName(lowAndHigh[1], r.names); err != nil {
+			return 0, err
+		}
+	}
+
+	if low < r.min {
+		return 0, fmt.Errorf("beginning of range (%d) below minimum (%d): %s", low, r.min, expr)
+	}
+	if high > r.max {
+		return 0, fmt.Errorf("end of range (%d) above maximum (%d): %s", high, r.max, expr)
+	}
+	if low > high {
+		return 0, fmt.Errorf("beginning of range (%d) beyond end of range (%d): %s", low, high, expr)
+	}
+
+	switch len(rangeAndStep) {
+	case 1:
+		return 1 << (low + uint(hash%uint64(high-low+1))), nil
+	case 2:
+		step, err := mustParseInt(rangeAndStep[1])
+		if err != nil {
+			return 0, err
+		}
+		if step == 0 {
+			return 0, fmt.Errorf("step of range should be a positive number: %s", expr)
+		}
+		offset := step
+		if span := high - low + 1; span < offset {
+			offset = span
+		}
+		return getBits(low+uint(hash%uint64(offset)), high, step), nil
+	default:
+		return 0, fmt.Errorf("too many slashes: %s", expr)
+	}
+}
+
 // getRange returns the bits indicated by the given expression:
 //   number | number "-" number [ "/" number ]
 // or error parsing range.
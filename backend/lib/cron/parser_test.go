@@ -77,6 +77,76 @@ func TestField(t *testing.T) {
 	}
 }
 
+func TestHashRange(t *testing.T) {
+	zero := uint64(0)
+	ranges := []struct {
+		expr     string
+		min, max uint
+		hash     uint64
+		expected uint64
+		err      string
+	}{
+		{"H", 0, 7, 0, 1 << 0, ""},
+		{"H", 0, 7, 13, 1 << 5, ""},
+		{"H(2-4)", 0, 7, 4, 1 << 3, ""},
+		{"H/3", 0, 7, 4, 1<<1 | 1<<4 | 1<<7, ""},
+		{"H(2-7)/2", 0, 7, 3, 1<<3 | 1<<5 | 1<<7, ""},
+		{"H(2-3)/5", 0, 7, 3, 1 << 3, ""},
+		{"H", 1, 31, 27, 1 << 28, ""},
+		{"H", 1, 31, 28, 1 << 1, ""},
+
+		{"Hx", 0, 7, 0, zero, "invalid hash expression"},
+		{"H(2)", 0, 7, 0, zero, "min-max"},
+		{"H(1-8)", 0, 7, 0, zero, "above maximum"},
+		{"H(5-3)", 0, 7, 0, zero, "beyond end of range"},
+		{"H/0", 0, 7, 0, zero, "should be a positive number"},
+		{"H//2", 0, 7, 0, zero, "too many slashes"},
+	}
+
+	for _, c := range ranges {
+		actual, err := getHashRange(c.expr, bounds{c.min, c.max, nil}, c.hash)
+		if len(c.err) != 0 && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s => expected %v, got %v", c.expr, c.err, err)
+		}
+		if len(c.err) == 0 && err != nil {
+			t.Errorf("%s => unexpected error %v", c.expr, err)
+		}
+		if actual != c.expected {
+			t.Errorf("%s => expected %d, got %d", c.expr, c.expected, actual)
+		}
+	}
+}
+
+func TestHashKey(t *testing.T) {
+	spec := "0 H H * * *"
+	a1, err := secondParser.WithHashKey("a").Parse(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, _ := secondParser.WithHashKey("a").Parse(spec)
+	if !reflect.DeepEqual(a1, a2) {
+		t.Errorf("%s => expected the same schedule for the same key", spec)
+	}
+
+	// Different keys should spread the fire times.
+	times := map[time.Time]bool{}
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		sched, err := secondParser.WithHashKey(key).Parse(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := sched.Next(from)
+		if next.Sub(from) > 24*time.Hour {
+			t.Errorf("%s => expected to fire within a day, got %v", spec, next)
+		}
+		times[next] = true
+	}
+	if len(times) < 2 {
+		t.Errorf("%s => expected different fire times for different keys", spec)
+	}
+}
+
 func TestAll(t *testing.T) {
 	allBits := []struct {
 		r        bounds
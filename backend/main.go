@@ -122,6 +122,22 @@ func main() {
 			panic(err)
 		}
 		log.Info("initialized clean service successfully")
+
+		// 初始化任务队列对账
+		if err := services.InitTaskReconciler(); err != nil {
+			log.Error("init task reconciler error:" + err.Error())
+			debug.PrintStack()
+			panic(err)
+		}
+		log.Info("initialized task reconciler successfully")
+
+		// 初始化延迟重试任务入队
+		if err := services.InitDelayedTaskEnqueuer(); err != nil {
+			log.Error("init delayed task enqueuer error:" + err.Error())
+			debug.PrintStack()
+			panic(err)
+		}
+		log.Info("initialized delayed task enqueuer successfully")
 	}
 
 	// 初始化任务执行器
@@ -140,6 +156,16 @@ func main() {
 	}
 	log.Info("initialized spider service successfully")
 
+	if model.IsMaster() {
+		// 初始化调度节点选举（持有租约的主节点运行定时任务）
+		if err := services.InitLeaderElection(); err != nil {
+			log.Error("init leader election error:" + err.Error())
+			debug.PrintStack()
+			panic(err)
+		}
+		log.Info("initialized leader election successfully")
+	}
+
 	// 初始化RPC服务
 	if err := rpc.InitRpcService(); err != nil {
 		log.Error("init rpc service error:" + err.Error())
@@ -171,6 +197,8 @@ func main() {
 			anonymousGroup.GET("/releases/latest", routes.GetLatestRelease) // 获取最近发布的版本
 			// 文档
 			anonymousGroup.GET("/docs", routes.GetDocs) // 获取文档数据
+			// Web Hook（签名校验）
+			anonymousGroup.POST("/webhooks/:id/trigger", routes.TriggerWebhook) // 触发 Web Hook
 		}
 		authGroup := app.Group("/", middlewares.AuthorizationMiddleware())
 		{
@@ -188,6 +216,9 @@ func main() {
 				authGroup.POST("/nodes/:id/deps/install", routes.InstallDep)           // 节点安装依赖
 				authGroup.POST("/nodes/:id/deps/uninstall", routes.UninstallDep)       // 节点卸载依赖
 				authGroup.POST("/nodes/:id/langs/install", routes.InstallLang)         // 节点安装语言
+				authGroup.POST("/nodes/:id/drain", routes.DrainNode)                   // 排空节点
+				authGroup.GET("/nodes/:id/drain", routes.GetNodeDrainStatus)           // 节点排空进度
+				authGroup.DELETE("/nodes/:id/drain", routes.ResumeNode)                // 结束排空节点
 			}
 			// 爬虫
 			{
@@ -242,17 +273,25 @@ func main() {
 				authGroup.GET("/tasks/:id", routes.GetTask)                                 // 任务详情
 				authGroup.PUT("/tasks", routes.PutTask)                                     // 派发任务
 				authGroup.PUT("/tasks/batch", routes.PutBatchTasks)                         // 批量派发任务
+				authGroup.PUT("/tasks/matrix", routes.PutMatrixTasks)                       // 按参数矩阵批量派发任务
 				authGroup.DELETE("/tasks/:id", routes.DeleteTask)                           // 删除任务
 				authGroup.DELETE("/tasks", routes.DeleteSelectedTask)                       // 删除多个任务
 				authGroup.DELETE("/tasks_by_status", routes.DeleteTaskByStatus)             // 删除指定状态的任务
 				authGroup.POST("/tasks/:id/cancel", routes.CancelTask)                      // 取消任务
 				authGroup.GET("/tasks/:id/log", routes.GetTaskLog)                          // 任务日志
+				authGroup.GET("/tasks/:id/log/stream", routes.StreamTaskLog)                // 任务实时日志（SSE）
 				authGroup.GET("/tasks/:id/error-log", routes.GetTaskErrorLog)               // 任务错误日志
+				authGroup.GET("/tasks/:id/metrics", routes.GetTaskMetrics)                  // 任务资源使用
 				authGroup.GET("/tasks/:id/results", routes.GetTaskResults)                  // 任务结果
 				authGroup.GET("/tasks/:id/results/download", routes.DownloadTaskResultsCsv) // 下载任务结果
 				authGroup.POST("/tasks/:id/restart", routes.RestartTask)                    // 重新开始任务
+				authGroup.POST("/tasks/:id/priority", routes.PostTaskPriority)              // 修改任务优先级
+				authGroup.GET("/task-batches/:id", routes.GetTaskBatch)                     // 批量任务统计
+				authGroup.POST("/task-batches/:id/cancel", routes.CancelTaskBatch)          // 取消批量任务
+				authGroup.POST("/task-batches/:id/restart", routes.RestartTaskBatch)        // 重新运行批量任务
 				authGroup.POST("/tasks-cancel", routes.CancelSelectedTask)                  // 批量取消任务
 				authGroup.POST("/tasks-restart", routes.RestartSelectedTask)                // 批量重试任务
+				authGroup.POST("/tasks-reconcile", routes.ReconcileTaskQueues)              // 任务队列对账
 			}
 			// 系统任务/脚本
 			{
@@ -271,6 +310,35 @@ func main() {
 				authGroup.POST("/schedules/:id/disable", routes.DisableSchedule)     // 禁用定时任务
 				authGroup.POST("/schedules/:id/enable", routes.EnableSchedule)       // 启用定时任务
 				authGroup.POST("/schedules-set-enabled", routes.SetEnabledSchedules) // 批量设置定时任务状态
+				authGroup.GET("/schedules-preview", routes.PreviewScheduleCron)      // 预览cron表达式触发时间
+				authGroup.GET("/schedules/:id/history", routes.GetScheduleHistory)   // 定时任务运行历史及统计
+				authGroup.GET("/schedules/:id/fires", routes.GetScheduleFires)       // 定时任务触发记录
+			}
+			// 日历
+			{
+				authGroup.GET("/calendars", routes.GetCalendarList)       // 日历列表
+				authGroup.GET("/calendars/:id", routes.GetCalendar)       // 日历详情
+				authGroup.PUT("/calendars", routes.PutCalendar)           // 创建日历
+				authGroup.POST("/calendars/:id", routes.PostCalendar)     // 修改日历
+				authGroup.DELETE("/calendars/:id", routes.DeleteCalendar) // 删除日历
+			}
+			// Web Hook
+			{
+				authGroup.GET("/webhooks", routes.GetWebhookList)                // Web Hook 列表
+				authGroup.GET("/webhooks/:id", routes.GetWebhook)                // Web Hook 详情
+				authGroup.PUT("/webhooks", routes.PutWebhook)                    // 创建 Web Hook
+				authGroup.POST("/webhooks/:id", routes.PostWebhook)              // 修改 Web Hook
+				authGroup.DELETE("/webhooks/:id", routes.DeleteWebhook)          // 删除 Web Hook
+				authGroup.POST("/webhooks/:id/secret", routes.PostWebhookSecret) // 重新生成 Web Hook 密钥
+			}
+			// 工作流
+			{
+				authGroup.GET("/workflows", routes.GetWorkflowList)       // 工作流列表
+				authGroup.GET("/workflows/:id", routes.GetWorkflow)       // 工作流详情
+				authGroup.PUT("/workflows", routes.PutWorkflow)           // 创建工作流
+				authGroup.POST("/workflows/:id", routes.PostWorkflow)     // 修改工作流
+				authGroup.DELETE("/workflows/:id", routes.DeleteWorkflow) // 删除工作流
+				authGroup.POST("/workflows/:id/run", routes.RunWorkflow)  // 运行工作流
 			}
 			// 用户
 			{
@@ -362,6 +430,13 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
+
+	// 释放调度租约，由其他主节点接管定时任务
+	services.ResignLeader()
+
+	// 排空节点：不再获取新任务，等待运行中的任务结束
+	services.DrainLocalNode()
+
 	ctx2, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx2); err != nil {
@@ -9,11 +9,19 @@ import (
 	"strings"
 )
 
+// 任务实时日志（SSE）路由
+const taskLogStreamPath = "/tasks/:id/log/stream"
+
 func AuthorizationMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 获取token string
 		tokenStr := c.GetHeader("Authorization")
 
+		// 浏览器 EventSource 无法设置请求头，只有任务实时日志的请求可以通过 token 参数传递
+		if tokenStr == "" && c.FullPath() == taskLogStreamPath {
+			tokenStr = c.Query("token")
+		}
+
 		// 校验token
 		user, err := services.CheckToken(tokenStr)
 
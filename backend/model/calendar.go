@@ -0,0 +1,95 @@
+package model
+
+import (
+	"crawlab/database"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"runtime/debug"
+	"time"
+)
+
+// 日历中的周期性时间窗口
+type CalendarWindow struct {
+	Weekdays []int  `json:"weekdays" bson:"weekdays"` // 星期（0 为星期日），为空则每天
+	Start    string `json:"start" bson:"start"`       // 开始时间 HH:MM
+	End      string `json:"end" bson:"end"`           // 结束时间 HH:MM，早于开始时间时表示跨过午夜
+}
+
+// 日历（如节假日、维护窗口），定时任务可引用日历作为排除时间
+type Calendar struct {
+	Id          bson.ObjectId    `json:"_id" bson:"_id"`
+	Name        string           `json:"name" bson:"name"`
+	Description string           `json:"description" bson:"description"`
+	Timezone    string           `json:"timezone" bson:"timezone"` // 时区，为空则使用定时任务的时区
+	Dates       []string         `json:"dates" bson:"dates"`       // 日期 YYYY-MM-DD
+	Windows     []CalendarWindow `json:"windows" bson:"windows"`   // 周期性时间窗口
+
+	UserId   bson.ObjectId `json:"user_id" bson:"user_id"`
+	CreateTs time.Time     `json:"create_ts" bson:"create_ts"`
+	UpdateTs time.Time     `json:"update_ts" bson:"update_ts"`
+}
+
+func (cal *Calendar) Save() error {
+	s, c := database.GetCol("calendars")
+	defer s.Close()
+	cal.UpdateTs = time.Now()
+	if err := c.UpdateId(cal.Id, cal); err != nil {
+		log.Errorf("update calendar error: %s", err.Error())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func (cal *Calendar) Add() error {
+	s, c := database.GetCol("calendars")
+	defer s.Close()
+	cal.Id = bson.NewObjectId()
+	cal.CreateTs = time.Now()
+	cal.UpdateTs = time.Now()
+	if err := c.Insert(cal); err != nil {
+		log.Errorf("add calendar error: %s", err.Error())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func (cal *Calendar) Delete() error {
+	s, c := database.GetCol("calendars")
+	defer s.Close()
+	if err := c.RemoveId(cal.Id); err != nil {
+		log.Errorf("remove calendar error: %s", err.Error())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func GetCalendarList(filter interface{}) ([]Calendar, error) {
+	s, c := database.GetCol("calendars")
+	defer s.Close()
+
+	var calendars []Calendar
+	if err := c.Find(filter).Sort("-create_ts").All(&calendars); err != nil {
+		debug.PrintStack()
+		return calendars, err
+	}
+	return calendars, nil
+}
+
+func GetCalendar(id bson.ObjectId) (Calendar, error) {
+	s, c := database.GetCol("calendars")
+	defer s.Close()
+
+	var cal Calendar
+	if err := c.FindId(id).One(&cal); err != nil {
+		if err != mgo.ErrNotFound {
+			log.Errorf("get calendar error: %s, id: %s", err.Error(), id.Hex())
+			debug.PrintStack()
+		}
+		return cal, err
+	}
+	return cal, nil
+}
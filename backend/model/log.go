@@ -12,12 +12,15 @@ import (
 )
 
 type LogItem struct {
-	Id       bson.ObjectId `json:"_id" bson:"_id"`
-	Message  string        `json:"msg" bson:"msg"`
-	TaskId   string        `json:"task_id" bson:"task_id"`
-	Seq      int64         `json:"seq" bson:"seq"`
-	Ts       time.Time     `json:"ts" bson:"ts"`
-	ExpireTs time.Time     `json:"expire_ts" bson:"expire_ts"`
+	Id       bson.ObjectId          `json:"_id" bson:"_id"`
+	Message  string                 `json:"msg" bson:"msg"`
+	TaskId   string                 `json:"task_id" bson:"task_id"`
+	Seq      int64                  `json:"seq" bson:"seq"`
+	Stream   string                 `json:"stream" bson:"stream"`                     // 来源 stdout/stderr
+	Level    string                 `json:"level" bson:"level"`                       // 解析出的日志级别，无法识别时为空
+	Fields   map[string]interface{} `json:"fields,omitempty" bson:"fields,omitempty"` // 结构化字段（如 JSON 日志中的字段、logger 名称）
+	Ts       time.Time              `json:"ts" bson:"ts"`
+	ExpireTs time.Time              `json:"expire_ts" bson:"expire_ts"`
 }
 
 type ErrorLogItem struct {
@@ -117,7 +120,8 @@ func GetLogItemList(query bson.M, keyword string, skip int, limit int, sortStr s
 	filter := query
 
 	var logItems []LogItem
-	if keyword == "" {
+	if keyword == "" && filter["level"] == nil && filter["stream"] == nil {
+		// 未过滤时按 seq 范围分页
 		filter["seq"] = bson.M{
 			"$gte": skip,
 			"$lt":  skip + limit,
@@ -127,11 +131,13 @@ func GetLogItemList(query bson.M, keyword string, skip int, limit int, sortStr s
 			return logItems, err
 		}
 	} else {
-		filter["msg"] = bson.M{
-			"$regex": bson.RegEx{
-				Pattern: keyword,
-				Options: "i",
-			},
+		if keyword != "" {
+			filter["msg"] = bson.M{
+				"$regex": bson.RegEx{
+					Pattern: keyword,
+					Options: "i",
+				},
+			}
 		}
 		if err := c.Find(filter).Sort(sortStr).Skip(skip).Limit(limit).All(&logItems); err != nil {
 			debug.PrintStack()
@@ -142,6 +148,20 @@ func GetLogItemList(query bson.M, keyword string, skip int, limit int, sortStr s
 	return logItems, nil
 }
 
+// 获取 seq 之后的日志（按 seq 排序）
+func GetLogItemsAfterSeq(taskId string, seq int64, limit int) ([]LogItem, error) {
+	s, c := database.GetCol("logs")
+	defer s.Close()
+
+	var logItems []LogItem
+	query := bson.M{"task_id": taskId, "seq": bson.M{"$gt": seq}}
+	if err := c.Find(query).Sort("seq").Limit(limit).All(&logItems); err != nil {
+		debug.PrintStack()
+		return logItems, err
+	}
+	return logItems, nil
+}
+
 func GetLogItemTotal(query bson.M, keyword string) (int, error) {
 	s, c := database.GetCol("logs")
 	defer s.Close()
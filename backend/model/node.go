@@ -23,9 +23,18 @@ type Node struct {
 	Description string        `json:"description" bson:"description"`
 	// 用于唯一标识节点，可能是mac地址，可能是ip地址
 	Key string `json:"key" bson:"key"`
+	// 最大同时运行任务数（0为不限制）
+	MaxRunners int `json:"max_runners" bson:"max_runners"`
+	// 排空：不再获取新任务，等待运行中的任务结束
+	Draining      bool      `json:"draining" bson:"draining"`
+	DrainStartTs  time.Time `json:"drain_start_ts" bson:"drain_start_ts"`
+	DrainDeadline time.Time `json:"drain_deadline" bson:"drain_deadline"` // 超过该时间后取消仍在运行的任务（为空则一直等待）
+	DrainIdle     bool      `json:"drain_idle" bson:"drain_idle"`         // 节点已停止获取任务且没有正在执行的任务（由节点上报）
 
 	// 前端展示
-	IsMaster bool `json:"is_master" bson:"is_master"`
+	IsMaster         bool `json:"is_master" bson:"is_master"`
+	IsLeader         bool `json:"is_leader" bson:"-"` // 调度节点（运行定时任务的主节点）
+	RunningTaskCount int  `json:"running_task_count"`
 
 	UpdateTs     time.Time `json:"update_ts" bson:"update_ts"`
 	CreateTs     time.Time `json:"create_ts" bson:"create_ts"`
@@ -86,6 +95,47 @@ func (n *Node) GetTasks() ([]Task, error) {
 	return tasks, nil
 }
 
+// 节点运行中的任务数
+func (n *Node) GetRunningTaskCount() (int, error) {
+	return GetTaskCount(bson.M{"node_id": n.Id, "status": constants.StatusRunning})
+}
+
+// 设置节点排空状态
+func SetNodeDrain(id bson.ObjectId, draining bool, deadline time.Time) error {
+	s, c := database.GetCol("nodes")
+	defer s.Close()
+
+	update := bson.M{
+		"draining":       draining,
+		"drain_deadline": deadline,
+		"drain_idle":     false,
+	}
+	if draining {
+		update["drain_start_ts"] = time.Now()
+	} else {
+		update["drain_start_ts"] = time.Time{}
+	}
+	if err := c.UpdateId(id, bson.M{"$set": update}); err != nil {
+		log.Errorf("set node drain error: %s, id: %s", err.Error(), id.Hex())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+// 设置节点是否已排空（由节点自身上报）
+func SetNodeDrainIdle(id bson.ObjectId, idle bool) error {
+	s, c := database.GetCol("nodes")
+	defer s.Close()
+
+	if err := c.UpdateId(id, bson.M{"$set": bson.M{"drain_idle": idle}}); err != nil {
+		log.Errorf("set node drain idle error: %s, id: %s", err.Error(), id.Hex())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
 // 节点列表
 func GetNodeList(filter interface{}) ([]Node, error) {
 	s, c := database.GetCol("nodes")
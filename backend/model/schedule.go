@@ -16,7 +16,14 @@ type Schedule struct {
 	Name           string          `json:"name" bson:"name"`
 	Description    string          `json:"description" bson:"description"`
 	SpiderId       bson.ObjectId   `json:"spider_id" bson:"spider_id"`
+	Type           string          `json:"type" bson:"type"` // 类型：cron/interval/once，为空则为 cron
 	Cron           string          `json:"cron" bson:"cron"`
+	Interval       int             `json:"interval" bson:"interval"`   // 运行间隔（秒），interval 类型有效
+	StartTs        time.Time       `json:"start_ts" bson:"start_ts"`   // interval 类型的起始时间，once 类型的运行时间
+	EndTs          time.Time       `json:"end_ts" bson:"end_ts"`       // 结束时间，之后不再运行
+	Jitter         int             `json:"jitter" bson:"jitter"`       // 随机延迟创建任务的窗口（秒），避免大量定时任务同时运行
+	MaxRuns        int             `json:"max_runs" bson:"max_runs"`   // 最多运行次数，0 为不限制
+	RunCount       int             `json:"run_count" bson:"run_count"` // 已运行次数
 	EntryId        cron.EntryID    `json:"entry_id" bson:"entry_id"`
 	Param          string          `json:"param" bson:"param"`
 	RunType        string          `json:"run_type" bson:"run_type"`
@@ -26,12 +33,28 @@ type Schedule struct {
 	UserId         bson.ObjectId   `json:"user_id" bson:"user_id"`
 	ScrapySpider   string          `json:"scrapy_spider" bson:"scrapy_spider"`
 	ScrapyLogLevel string          `json:"scrapy_log_level" bson:"scrapy_log_level"`
+	Priority       int             `json:"priority" bson:"priority"`
+	Timeout        int             `json:"timeout" bson:"timeout"`
+	OverlapPolicy  string          `json:"overlap_policy" bson:"overlap_policy"`     // 重叠策略：allow/skip/queue/replace
+	Timezone       string          `json:"timezone" bson:"timezone"`                 // 时区（如 America/New_York），用于计算触发时间和任务的 TZ 环境变量
+	MisfirePolicy  string          `json:"misfire_policy" bson:"misfire_policy"`     // 主节点停机期间错过触发的处理策略：ignore/run_once/run_all
+	MisfireMaxRuns int             `json:"misfire_max_runs" bson:"misfire_max_runs"` // run_all 最多补跑次数，0 为使用默认值
+	LastFireTs     time.Time       `json:"last_fire_ts" bson:"last_fire_ts"`         // 上一次触发时间
+	CalendarIds    []bson.ObjectId `json:"calendar_ids" bson:"calendar_ids"`         // 排除日历，日历中的日期和时间窗口内不运行
+
+	// 重试策略（设置后覆盖爬虫的重试策略）
+	MaxRetries   int      `json:"max_retries" bson:"max_retries"`
+	RetryBackoff int      `json:"retry_backoff" bson:"retry_backoff"`
+	RetryOn      []string `json:"retry_on" bson:"retry_on"`
 
 	// 前端展示
-	SpiderName string `json:"spider_name" bson:"spider_name"`
-	Username   string `json:"user_name" bson:"user_name"`
-	Nodes      []Node `json:"nodes" bson:"nodes"`
-	Message    string `json:"message" bson:"message"`
+	SpiderName string    `json:"spider_name" bson:"spider_name"`
+	Username   string    `json:"user_name" bson:"user_name"`
+	Nodes      []Node    `json:"nodes" bson:"nodes"`
+	Message    string    `json:"message" bson:"message"`
+	Health     string    `json:"health" bson:"-"`
+	NextRunTs  time.Time `json:"next_run_ts"` // 下一次触发时间
+	PrevRunTs  time.Time `json:"prev_run_ts"` // 上一次触发时间
 
 	CreateTs time.Time `json:"create_ts" bson:"create_ts"`
 	UpdateTs time.Time `json:"update_ts" bson:"update_ts"`
@@ -125,13 +148,103 @@ func UpdateSchedule(id bson.ObjectId, item Schedule) error {
 		return err
 	}
 
+	// interval 类型未设置起始时间时从现在开始
+	if item.Type == constants.ScheduleTypeInterval && item.StartTs.IsZero() {
+		item.StartTs = time.Now()
+	}
 	item.UpdateTs = time.Now()
-	if err := item.Save(); err != nil {
+
+	data, err := bson.Marshal(item)
+	if err != nil {
+		return err
+	}
+	update := bson.M{}
+	if err := bson.Unmarshal(data, &update); err != nil {
+		return err
+	}
+
+	// 状态、EntryID、触发时间和运行次数由定时任务服务维护，不覆盖并发写入的值
+	delete(update, "_id")
+	delete(update, "status")
+	delete(update, "entry_id")
+	delete(update, "last_fire_ts")
+	delete(update, "run_count")
+
+	// 重新启用时清空，不补跑禁用期间的触发
+	if item.Enabled && !result.Enabled {
+		update["last_fire_ts"] = time.Time{}
+		update["run_count"] = 0
+	}
+
+	if err := c.UpdateId(id, bson.M{"$set": update}); err != nil {
 		return err
 	}
 	return nil
 }
 
+// 定时任务运行次数加一，返回加一后的运行次数
+func IncScheduleRunCount(id bson.ObjectId) (int, error) {
+	s, c := database.GetCol("schedules")
+	defer s.Close()
+
+	var result Schedule
+	change := mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"run_count": 1}},
+		ReturnNew: true,
+	}
+	if _, err := c.FindId(id).Apply(change, &result); err != nil {
+		return 0, err
+	}
+	return result.RunCount, nil
+}
+
+// 更新定时任务的 cron EntryID 和状态
+// 只更新指定字段，不覆盖触发任务时并发写入的运行次数和触发时间
+func UpdateScheduleEntry(id bson.ObjectId, entryId cron.EntryID, status string, enabled bool) error {
+	return updateScheduleFields(id, bson.M{
+		"entry_id": entryId,
+		"status":   status,
+		"enabled":  enabled,
+	})
+}
+
+// 更新定时任务的状态
+func UpdateScheduleStatus(id bson.ObjectId, status string, enabled bool) error {
+	return updateScheduleFields(id, bson.M{
+		"status":  status,
+		"enabled": enabled,
+	})
+}
+
+// 清空定时任务的触发时间和运行次数（重新启用时不补跑禁用期间的触发）
+func ResetScheduleRunState(id bson.ObjectId) error {
+	return updateScheduleFields(id, bson.M{
+		"last_fire_ts": time.Time{},
+		"run_count":    0,
+	})
+}
+
+func updateScheduleFields(id bson.ObjectId, update bson.M) error {
+	s, c := database.GetCol("schedules")
+	defer s.Close()
+
+	update["update_ts"] = time.Now()
+	if err := c.UpdateId(id, bson.M{"$set": update}); err != nil {
+		log.Errorf("update schedule error: %s, id: %s", err.Error(), id.Hex())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+// 更新定时任务的上一次触发时间（只会往后更新）
+func UpdateScheduleLastFireTs(id bson.ObjectId, ts time.Time) error {
+	s, c := database.GetCol("schedules")
+	defer s.Close()
+
+	return c.UpdateId(id, bson.M{"$max": bson.M{"last_fire_ts": ts}})
+}
+
 func AddSchedule(item Schedule) error {
 	s, c := database.GetCol("schedules")
 	defer s.Close()
@@ -140,6 +253,11 @@ func AddSchedule(item Schedule) error {
 	item.CreateTs = time.Now()
 	item.UpdateTs = time.Now()
 
+	// interval 类型未设置起始时间时从现在开始
+	if item.Type == constants.ScheduleTypeInterval && item.StartTs.IsZero() {
+		item.StartTs = item.CreateTs
+	}
+
 	if err := c.Insert(&item); err != nil {
 		debug.PrintStack()
 		log.Errorf(err.Error())
@@ -161,6 +279,12 @@ func RemoveSchedule(id bson.ObjectId) error {
 		return err
 	}
 
+	// 删除触发记录
+	_ = RemoveScheduleHistory(id)
+
+	// 删除 Web Hook
+	_ = RemoveWebhooks(bson.M{"schedule_id": id})
+
 	return nil
 }
 
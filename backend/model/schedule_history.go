@@ -0,0 +1,72 @@
+package model
+
+import (
+	"crawlab/database"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo/bson"
+	"github.com/spf13/viper"
+	"runtime/debug"
+	"time"
+)
+
+// 定时任务触发记录
+type ScheduleHistory struct {
+	Id         bson.ObjectId `json:"_id" bson:"_id"`
+	ScheduleId bson.ObjectId `json:"schedule_id" bson:"schedule_id"`
+	FireTs     time.Time     `json:"fire_ts" bson:"fire_ts"`   // 触发时间
+	Status     string        `json:"status" bson:"status"`     // triggered/skipped/error
+	Reason     string        `json:"reason" bson:"reason"`     // 跳过或出错的原因
+	TaskIds    []string      `json:"task_ids" bson:"task_ids"` // 创建的任务
+	CatchUp    bool          `json:"catch_up" bson:"catch_up"` // 补跑错过的触发
+	CreateTs   time.Time     `json:"create_ts" bson:"create_ts"`
+	ExpireTs   time.Time     `json:"expire_ts" bson:"expire_ts,omitempty"` // 过期时间，过期后自动删除
+}
+
+func (h *ScheduleHistory) Add() error {
+	s, c := database.GetCol("schedule_history")
+	defer s.Close()
+	h.Id = bson.NewObjectId()
+	h.CreateTs = time.Now()
+
+	// 触发记录保留天数，0 为一直保留
+	if days := viper.GetInt("task.scheduleHistoryExpireDays"); days > 0 {
+		h.ExpireTs = h.CreateTs.Add(time.Duration(days) * 24 * time.Hour)
+	}
+	if err := c.Insert(h); err != nil {
+		log.Errorf("add schedule history error: %s", err.Error())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func GetScheduleHistoryList(filter interface{}, skip int, limit int) ([]ScheduleHistory, error) {
+	s, c := database.GetCol("schedule_history")
+	defer s.Close()
+
+	var list []ScheduleHistory
+	if err := c.Find(filter).Sort("-fire_ts").Skip(skip).Limit(limit).All(&list); err != nil {
+		debug.PrintStack()
+		return list, err
+	}
+	return list, nil
+}
+
+func GetScheduleHistoryTotal(filter interface{}) (int, error) {
+	s, c := database.GetCol("schedule_history")
+	defer s.Close()
+
+	return c.Find(filter).Count()
+}
+
+func RemoveScheduleHistory(scheduleId bson.ObjectId) error {
+	s, c := database.GetCol("schedule_history")
+	defer s.Close()
+
+	if _, err := c.RemoveAll(bson.M{"schedule_id": scheduleId}); err != nil {
+		log.Errorf("remove schedule history error: %s", err.Error())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
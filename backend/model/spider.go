@@ -59,6 +59,28 @@ type Spider struct {
 	// 长任务
 	IsLongTask bool `json:"is_long_task" bson:"is_long_task"` // 是否为长任务
 
+	// 并发控制
+	MaxConcurrency int `json:"max_concurrency" bson:"max_concurrency"` // 最大并发任务数（0为不限制）
+
+	// 超时
+	Timeout int `json:"timeout" bson:"timeout"` // 任务超时时间（秒，0为不限制）
+
+	// 时区
+	Timezone string `json:"timezone" bson:"timezone"` // 任务的 TZ 环境变量（为空则使用 task.timezone 配置）
+
+	// 节点故障
+	RestartOnNodeFailure bool `json:"restart_on_node_failure" bson:"restart_on_node_failure"` // 节点离线时将任务重新放入公共队列
+
+	// 资源限制（仅Linux，CPU和内存需要cgroup v2）
+	CpuLimit     float64 `json:"cpu_limit" bson:"cpu_limit"`           // CPU核数（0为不限制）
+	MemoryLimit  int     `json:"memory_limit" bson:"memory_limit"`     // 内存（MB，0为不限制）
+	MaxOpenFiles int     `json:"max_open_files" bson:"max_open_files"` // 最大打开文件数（0为不限制）
+
+	// 重试策略
+	MaxRetries   int      `json:"max_retries" bson:"max_retries"`     // 最大重试次数（0为不重试）
+	RetryBackoff int      `json:"retry_backoff" bson:"retry_backoff"` // 重试间隔（秒），每次重试翻倍
+	RetryOn      []string `json:"retry_on" bson:"retry_on"`           // 触发重试的任务状态（error/abnormal）
+
 	// 去重
 	IsDedup     bool   `json:"is_dedup" bson:"is_dedup"`         // 是否去重
 	DedupField  string `json:"dedup_field" bson:"dedup_field"`   // 去重字段
@@ -69,12 +91,13 @@ type Spider struct {
 	WebHookUrl string `json:"web_hook_url" bson:"web_hook_url"` // Web Hook URL
 
 	// 前端展示
-	LastRunTs   time.Time               `json:"last_run_ts"`  // 最后一次执行时间
-	LastStatus  string                  `json:"last_status"`  // 最后执行状态
-	Config      entity.ConfigSpiderData `json:"config"`       // 可配置爬虫配置
-	LatestTasks []Task                  `json:"latest_tasks"` // 最近任务列表
-	Username    string                  `json:"username"`     // 用户名称
-	ProjectName string                  `json:"project_name"` // 项目名称
+	LastRunTs        time.Time               `json:"last_run_ts"`        // 最后一次执行时间
+	LastStatus       string                  `json:"last_status"`        // 最后执行状态
+	Config           entity.ConfigSpiderData `json:"config"`             // 可配置爬虫配置
+	LatestTasks      []Task                  `json:"latest_tasks"`       // 最近任务列表
+	Username         string                  `json:"username"`           // 用户名称
+	ProjectName      string                  `json:"project_name"`       // 项目名称
+	RunningTaskCount int                     `json:"running_task_count"` // 运行中任务数
 
 	// 时间
 	UserId   bson.ObjectId `json:"user_id" bson:"user_id"`
@@ -154,6 +177,11 @@ func (spider *Spider) GetLatestTasks(latestN int) (tasks []Task, err error) {
 	return tasks, nil
 }
 
+// 爬虫运行中的任务数
+func (spider *Spider) GetRunningTaskCount() (int, error) {
+	return GetTaskCount(bson.M{"spider_id": spider.Id, "status": constants.StatusRunning})
+}
+
 // 删除爬虫
 func (spider *Spider) Delete() error {
 	s, c := database.GetCol("spiders")
@@ -352,6 +380,9 @@ func RemoveSpider(id bson.ObjectId) error {
 		}
 	}
 
+	// 删除 Web Hook
+	_ = RemoveWebhooks(bson.M{"spider_id": id})
+
 	return nil
 }
 
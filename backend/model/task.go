@@ -31,6 +31,35 @@ type Task struct {
 	RunType         string        `json:"run_type" bson:"run_type"`
 	ScheduleId      bson.ObjectId `json:"schedule_id" bson:"schedule_id"`
 	Type            string        `json:"type" bson:"type"`
+	Priority        int           `json:"priority" bson:"priority"`
+	Timeout         int           `json:"timeout" bson:"timeout"`
+	ParentTaskId    string        `json:"parent_task_id" bson:"parent_task_id"`
+	RetryCount      int           `json:"retry_count" bson:"retry_count"`
+	BatchId         string        `json:"batch_id" bson:"batch_id"`
+	Envs            []Env         `json:"envs" bson:"envs"`
+	Timezone        string        `json:"timezone" bson:"timezone"`         // 任务的 TZ 环境变量（优先于爬虫的设置）
+	EnqueueTs       time.Time     `json:"enqueue_ts" bson:"enqueue_ts"`     // 加入任务队列的时间（延迟重试为预计入队时间）
+	Stale           bool          `json:"stale" bson:"stale"`               // 等待时间过长
+	Deferred        bool          `json:"deferred" bson:"deferred"`         // 等待定时任务上一次运行结束后再入队
+	Delayed         bool          `json:"delayed" bson:"delayed"`           // 延迟重试，到达入队时间后再入队
+	ScheduledTs     time.Time     `json:"scheduled_ts" bson:"scheduled_ts"` // 定时任务的触发时间
+	CatchUp         bool          `json:"catch_up" bson:"catch_up"`         // 补跑主节点停机期间错过的触发
+	Trigger         string        `json:"trigger" bson:"trigger"`           // 触发来源，为空则为手动或定时任务
+	WebhookId       bson.ObjectId `json:"webhook_id,omitempty" bson:"webhook_id,omitempty"`
+
+	// 工作流
+	WorkflowId      bson.ObjectId `json:"workflow_id,omitempty" bson:"workflow_id,omitempty"`
+	WorkflowRunId   bson.ObjectId `json:"workflow_run_id,omitempty" bson:"workflow_run_id,omitempty"`
+	WorkflowNodeKey string        `json:"workflow_node_key" bson:"workflow_node_key"`
+	UpstreamTaskId  string        `json:"upstream_task_id" bson:"upstream_task_id"`
+
+	// 资源使用
+	PeakCpu    float64 `json:"peak_cpu" bson:"peak_cpu"`       // CPU峰值（%）
+	AvgCpu     float64 `json:"avg_cpu" bson:"avg_cpu"`         // CPU平均值（%）
+	PeakMemory int64   `json:"peak_memory" bson:"peak_memory"` // 内存峰值（RSS，字节）
+	AvgMemory  int64   `json:"avg_memory" bson:"avg_memory"`   // 内存平均值（RSS，字节）
+	ReadBytes  int64   `json:"read_bytes" bson:"read_bytes"`   // 读取字节数
+	WriteBytes int64   `json:"write_bytes" bson:"write_bytes"` // 写入字节数
 
 	// 前端数据
 	SpiderName string   `json:"spider_name"`
@@ -43,6 +72,26 @@ type Task struct {
 	UpdateTs time.Time     `json:"update_ts" bson:"update_ts"`
 }
 
+// 批量任务统计
+type TaskBatchSummary struct {
+	BatchId      string         `json:"batch_id"`
+	Total        int            `json:"total"`
+	StatusCounts map[string]int `json:"status_counts"`
+	ResultCount  int            `json:"result_count"`
+}
+
+// 定时任务运行统计
+type ScheduleTaskStats struct {
+	TaskCount          int            `json:"task_count"`
+	StatusCounts       map[string]int `json:"status_counts"`
+	SuccessRate        float64        `json:"success_rate"`         // 成功任务数 / 已结束任务数（不含取消）
+	AvgRuntimeDuration float64        `json:"avg_runtime_duration"` // 成功任务的平均运行时长（秒）
+	AvgResultCount     float64        `json:"avg_result_count"`     // 成功任务的平均结果数
+	LastFailureReason  string         `json:"last_failure_reason"`
+	LastFailureTaskId  string         `json:"last_failure_task_id"`
+	LastFailureTs      time.Time      `json:"last_failure_ts"`
+}
+
 type TaskDailyItem struct {
 	Date               string  `json:"date" bson:"_id"`
 	TaskCount          int     `json:"task_count" bson:"task_count"`
@@ -112,10 +161,17 @@ func (t *Task) GetResults(pageNum int, pageSize int) (results []interface{}, tot
 	return
 }
 
-func (t *Task) GetLogItems(keyword string, page int, pageSize int) (logItems []LogItem, logTotal int, err error) {
+// 获取任务日志，可按关键词、日志级别和来源（stdout/stderr）过滤
+func (t *Task) GetLogItems(keyword string, levels []string, stream string, page int, pageSize int) (logItems []LogItem, logTotal int, err error) {
 	query := bson.M{
 		"task_id": t.Id,
 	}
+	if len(levels) > 0 {
+		query["level"] = bson.M{"$in": levels}
+	}
+	if stream != "" {
+		query["stream"] = stream
+	}
 
 	logTotal, err = GetLogItemTotal(query, keyword)
 	if err != nil {
@@ -213,6 +269,9 @@ func AddTask(item Task) error {
 
 	item.CreateTs = time.Now()
 	item.UpdateTs = time.Now()
+	if item.EnqueueTs.IsZero() {
+		item.EnqueueTs = item.CreateTs
+	}
 
 	if err := c.Insert(&item); err != nil {
 		log.Errorf(err.Error())
@@ -237,6 +296,9 @@ func RemoveTask(id string) error {
 		return err
 	}
 
+	// 删除资源使用采样
+	_ = RemoveTaskMetrics(id)
+
 	return nil
 }
 
@@ -373,6 +435,207 @@ func GetDailyTaskStats(query bson.M) ([]TaskDailyItem, error) {
 	return dailyItems, nil
 }
 
+// 批量任务中已被重试或重新运行的任务ID
+func GetTaskBatchParentIds(batchId string) ([]string, error) {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+
+	ids := []string{}
+	query := bson.M{"batch_id": batchId, "parent_task_id": bson.M{"$nin": []string{"", constants.ObjectIdNull}}}
+	if err := c.Find(query).Distinct("parent_task_id", &ids); err != nil {
+		log.Errorf("get task batch parent ids error: %s", err.Error())
+		debug.PrintStack()
+		return ids, err
+	}
+	return ids, nil
+}
+
+// 批量任务统计（各状态任务数、总结果数），重试或重新运行过的任务只统计最后一次的状态
+func GetTaskBatchSummary(batchId string) (TaskBatchSummary, error) {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+
+	summary := TaskBatchSummary{
+		BatchId:      batchId,
+		StatusCounts: map[string]int{},
+	}
+
+	parentIds, err := GetTaskBatchParentIds(batchId)
+	if err != nil {
+		return summary, err
+	}
+
+	op1 := bson.M{
+		"$match": bson.M{"batch_id": batchId},
+	}
+	op2 := bson.M{
+		"$group": bson.M{
+			"_id": "$status",
+			"count": bson.M{"$sum": bson.M{
+				"$cond": []interface{}{bson.M{"$in": []interface{}{"$_id", parentIds}}, 0, 1},
+			}},
+			"result_count": bson.M{"$sum": "$result_count"},
+		},
+	}
+
+	var items []struct {
+		Status      string `bson:"_id"`
+		Count       int    `bson:"count"`
+		ResultCount int    `bson:"result_count"`
+	}
+	if err := c.Pipe([]bson.M{op1, op2}).All(&items); err != nil {
+		log.Errorf("get task batch summary error: %s", err.Error())
+		debug.PrintStack()
+		return summary, err
+	}
+
+	for _, item := range items {
+		summary.ResultCount += item.ResultCount
+		if item.Count == 0 {
+			continue
+		}
+		summary.StatusCounts[item.Status] = item.Count
+		summary.Total += item.Count
+	}
+	return summary, nil
+}
+
+// 定时任务运行统计，since 不为空时只统计之后创建的任务
+func GetScheduleTaskStats(scheduleId bson.ObjectId, since time.Time) (ScheduleTaskStats, error) {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+
+	stats := ScheduleTaskStats{
+		StatusCounts: map[string]int{},
+	}
+
+	match := bson.M{"schedule_id": scheduleId}
+	if !since.IsZero() {
+		match["create_ts"] = bson.M{"$gte": since}
+	}
+	op1 := bson.M{
+		"$match": match,
+	}
+	op2 := bson.M{
+		"$group": bson.M{
+			"_id":              "$status",
+			"count":            bson.M{"$sum": 1},
+			"runtime_duration": bson.M{"$sum": "$runtime_duration"},
+			"result_count":     bson.M{"$sum": "$result_count"},
+		},
+	}
+
+	var items []struct {
+		Status          string  `bson:"_id"`
+		Count           int     `bson:"count"`
+		RuntimeDuration float64 `bson:"runtime_duration"`
+		ResultCount     int     `bson:"result_count"`
+	}
+	if err := c.Pipe([]bson.M{op1, op2}).All(&items); err != nil {
+		log.Errorf("get schedule task stats error: %s", err.Error())
+		debug.PrintStack()
+		return stats, err
+	}
+
+	failedCount := 0
+	for _, item := range items {
+		stats.StatusCounts[item.Status] = item.Count
+		stats.TaskCount += item.Count
+		switch item.Status {
+		case constants.StatusFinished:
+			if item.Count > 0 {
+				stats.AvgRuntimeDuration = item.RuntimeDuration / float64(item.Count)
+				stats.AvgResultCount = float64(item.ResultCount) / float64(item.Count)
+			}
+		case constants.StatusError, constants.StatusAbnormal, constants.StatusTimeout:
+			failedCount += item.Count
+		}
+	}
+	if successCount := stats.StatusCounts[constants.StatusFinished]; successCount+failedCount > 0 {
+		stats.SuccessRate = float64(successCount) / float64(successCount+failedCount)
+	}
+
+	// 最近一次失败
+	query := bson.M{
+		"schedule_id": scheduleId,
+		"status": bson.M{
+			"$in": []string{constants.StatusError, constants.StatusAbnormal, constants.StatusTimeout},
+		},
+	}
+	var t Task
+	if err := c.Find(query).Sort("-create_ts").One(&t); err == nil {
+		stats.LastFailureReason = t.Error
+		stats.LastFailureTaskId = t.Id
+		stats.LastFailureTs = GetTaskFailureTs(t)
+	}
+
+	return stats, nil
+}
+
+// 任务失败时间，异常任务（如节点离线）没有结束时间时取更新时间或创建时间
+func GetTaskFailureTs(t Task) time.Time {
+	if !t.FinishTs.IsZero() {
+		return t.FinishTs
+	}
+	if !t.UpdateTs.IsZero() {
+		return t.UpdateTs
+	}
+	return t.CreateTs
+}
+
+// 获取各定时任务最近 limit 个已结束任务的状态（按创建时间倒序）
+func GetScheduleRecentTaskStatuses(scheduleIds []bson.ObjectId, limit int) (map[bson.ObjectId][]string, error) {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+
+	results := map[bson.ObjectId][]string{}
+	if len(scheduleIds) == 0 {
+		return results, nil
+	}
+
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{
+				"schedule_id": bson.M{"$in": scheduleIds},
+				"status": bson.M{
+					"$in": []string{
+						constants.StatusFinished,
+						constants.StatusError,
+						constants.StatusAbnormal,
+						constants.StatusTimeout,
+					},
+				},
+			},
+		},
+		{"$sort": bson.M{"create_ts": -1}},
+		{
+			"$group": bson.M{
+				"_id":      "$schedule_id",
+				"statuses": bson.M{"$push": "$status"},
+			},
+		},
+		{
+			"$project": bson.M{
+				"statuses": bson.M{"$slice": []interface{}{"$statuses", limit}},
+			},
+		},
+	}
+
+	var items []struct {
+		ScheduleId bson.ObjectId `bson:"_id"`
+		Statuses   []string      `bson:"statuses"`
+	}
+	if err := c.Pipe(pipeline).AllowDiskUse().All(&items); err != nil {
+		log.Errorf("get schedule recent task statuses error: %s", err.Error())
+		debug.PrintStack()
+		return results, err
+	}
+	for _, item := range items {
+		results[item.ScheduleId] = item.Statuses
+	}
+	return results, nil
+}
+
 // 更新task的结果数
 func UpdateTaskResultCount(id string) (err error) {
 	// 获取任务
@@ -484,12 +747,20 @@ func UpdateTaskErrorLogs(taskId string, errorRegexPattern string) error {
 		errorRegexPattern = constants.ErrorRegexPattern
 	}
 
+	// 匹配错误正则或解析出错误级别的日志
 	query := bson.M{
 		"task_id": taskId,
-		"msg": bson.M{
-			"$regex": bson.RegEx{
-				Pattern: errorRegexPattern,
-				Options: "i",
+		"$or": []bson.M{
+			{
+				"msg": bson.M{
+					"$regex": bson.RegEx{
+						Pattern: errorRegexPattern,
+						Options: "i",
+					},
+				},
+			},
+			{
+				"level": bson.M{"$in": []string{constants.LogLevelError, constants.LogLevelCritical}},
 			},
 		},
 	}
@@ -532,3 +803,129 @@ func GetTaskByFilter(filter bson.M) (t Task, err error) {
 
 	return t, nil
 }
+
+// 标记等待时间过长的任务
+func MarkTaskStale(id string) error {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+
+	selector := bson.M{
+		"_id":    id,
+		"status": constants.StatusPending,
+	}
+	if err := c.Update(selector, bson.M{"$set": bson.M{"stale": true}}); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		log.Errorf("mark task stale error: %s, id: %s", err.Error(), id)
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+// 获取已到达入队时间的延迟重试任务
+func GetDueDelayedTasks(now time.Time) ([]Task, error) {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+
+	query := bson.M{
+		"status":     constants.StatusPending,
+		"delayed":    true,
+		"enqueue_ts": bson.M{"$lte": now},
+	}
+	var tasks []Task
+	if err := c.Find(query).Sort("enqueue_ts").All(&tasks); err != nil {
+		log.Errorf("get delayed tasks error: %s", err.Error())
+		debug.PrintStack()
+		return tasks, err
+	}
+	return tasks, nil
+}
+
+// 将延迟重试的任务标记为已入队，返回是否标记成功（未被其他调用抢先）
+func ClaimDelayedTask(id string) (bool, error) {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+
+	selector := bson.M{
+		"_id":     id,
+		"status":  constants.StatusPending,
+		"delayed": true,
+	}
+	if err := c.Update(selector, bson.M{"$set": bson.M{"delayed": false}}); err != nil {
+		if err == mgo.ErrNotFound {
+			return false, nil
+		}
+		log.Errorf("claim delayed task error: %s, id: %s", err.Error(), id)
+		debug.PrintStack()
+		return false, err
+	}
+	return true, nil
+}
+
+// 将延后入队的任务标记为可入队，返回是否标记成功（未被其他调用抢先）
+func ClaimDeferredTask(id string) (bool, error) {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+
+	selector := bson.M{
+		"_id":      id,
+		"status":   constants.StatusPending,
+		"deferred": true,
+	}
+	if err := c.Update(selector, bson.M{"$set": bson.M{"deferred": false}}); err != nil {
+		if err == mgo.ErrNotFound {
+			return false, nil
+		}
+		log.Errorf("claim deferred task error: %s, id: %s", err.Error(), id)
+		debug.PrintStack()
+		return false, err
+	}
+	return true, nil
+}
+
+// 将等待中的任务标记为运行中，返回是否标记成功（未被取消或被其他执行器抢先）
+func ClaimPendingTask(id string) (bool, error) {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+
+	selector := bson.M{
+		"_id":    id,
+		"status": constants.StatusPending,
+	}
+	change := mgo.Change{
+		Update:    bson.M{"$set": bson.M{"status": constants.StatusRunning}},
+		ReturnNew: true,
+	}
+	var t Task
+	if _, err := c.Find(selector).Apply(change, &t); err != nil {
+		if err == mgo.ErrNotFound {
+			return false, nil
+		}
+		log.Errorf("claim pending task error: %s, id: %s", err.Error(), id)
+		debug.PrintStack()
+		return false, err
+	}
+	return true, nil
+}
+
+// 更新等待中任务的入队时间（等待重新入队期间不会被对账重复入队）
+func UpdateTaskEnqueueTs(id string, ts time.Time) error {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+
+	selector := bson.M{
+		"_id":    id,
+		"status": constants.StatusPending,
+	}
+	if err := c.Update(selector, bson.M{"$set": bson.M{"enqueue_ts": ts}}); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		log.Errorf("update task enqueue ts error: %s, id: %s", err.Error(), id)
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"crawlab/database"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo/bson"
+	"runtime/debug"
+	"time"
+)
+
+// 任务资源使用采样点
+type TaskMetric struct {
+	Id           bson.ObjectId `json:"_id" bson:"_id"`
+	TaskId       string        `json:"task_id" bson:"task_id"`
+	Cpu          float64       `json:"cpu" bson:"cpu"`                     // CPU使用率（%，单核为100）
+	Memory       int64         `json:"memory" bson:"memory"`               // 内存（RSS，字节）
+	ReadBytes    int64         `json:"read_bytes" bson:"read_bytes"`       // 累计读取字节数
+	WriteBytes   int64         `json:"write_bytes" bson:"write_bytes"`     // 累计写入字节数
+	ProcessCount int           `json:"process_count" bson:"process_count"` // 进程组内进程数
+	Ts           time.Time     `json:"ts" bson:"ts"`
+	ExpireTs     time.Time     `json:"expire_ts" bson:"expire_ts"`
+}
+
+// 任务资源使用统计
+type TaskResourceUsage struct {
+	PeakCpu    float64 `json:"peak_cpu" bson:"peak_cpu"`
+	AvgCpu     float64 `json:"avg_cpu" bson:"avg_cpu"`
+	PeakMemory int64   `json:"peak_memory" bson:"peak_memory"`
+	AvgMemory  int64   `json:"avg_memory" bson:"avg_memory"`
+	ReadBytes  int64   `json:"read_bytes" bson:"read_bytes"`
+	WriteBytes int64   `json:"write_bytes" bson:"write_bytes"`
+}
+
+func (m *TaskMetric) Add() error {
+	s, c := database.GetCol("task_metrics")
+	defer s.Close()
+	m.Id = bson.NewObjectId()
+	if err := c.Insert(m); err != nil {
+		log.Errorf("add task metric error: %s", err.Error())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func GetTaskMetricList(taskId string) ([]TaskMetric, error) {
+	s, c := database.GetCol("task_metrics")
+	defer s.Close()
+
+	var metrics []TaskMetric
+	if err := c.Find(bson.M{"task_id": taskId}).Sort("ts").All(&metrics); err != nil {
+		log.Errorf("get task metric list error: %s", err.Error())
+		debug.PrintStack()
+		return metrics, err
+	}
+	return metrics, nil
+}
+
+func RemoveTaskMetrics(taskId string) error {
+	s, c := database.GetCol("task_metrics")
+	defer s.Close()
+
+	if _, err := c.RemoveAll(bson.M{"task_id": taskId}); err != nil {
+		log.Errorf("remove task metrics error: %s", err.Error())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+// 只更新任务的资源使用统计字段，避免覆盖任务的其他字段
+func UpdateTaskResourceUsage(taskId string, usage TaskResourceUsage) error {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+
+	update := bson.M{
+		"$set": bson.M{
+			"peak_cpu":    usage.PeakCpu,
+			"avg_cpu":     usage.AvgCpu,
+			"peak_memory": usage.PeakMemory,
+			"avg_memory":  usage.AvgMemory,
+			"read_bytes":  usage.ReadBytes,
+			"write_bytes": usage.WriteBytes,
+		},
+	}
+	if err := c.UpdateId(taskId, update); err != nil {
+		log.Errorf("update task resource usage error: %s", err.Error())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package model
+
+import (
+	"crawlab/database"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"runtime/debug"
+	"time"
+)
+
+// 请求数据到环境变量的映射
+type WebhookEnv struct {
+	Name string `json:"name" bson:"name"` // 环境变量名
+	Path string `json:"path" bson:"path"` // 请求数据中的字段路径，如 data.id
+}
+
+// 入站 Web Hook，外部系统通过签名请求触发爬虫或定时任务
+type Webhook struct {
+	Id          bson.ObjectId `json:"_id" bson:"_id"`
+	Name        string        `json:"name" bson:"name"`
+	Description string        `json:"description" bson:"description"`
+	SpiderId    bson.ObjectId `json:"spider_id,omitempty" bson:"spider_id,omitempty"`     // 触发的爬虫
+	ScheduleId  bson.ObjectId `json:"schedule_id,omitempty" bson:"schedule_id,omitempty"` // 触发的定时任务（使用定时任务的运行配置），与爬虫二选一
+	Secret      string        `json:"secret" bson:"secret"`                               // 签名密钥
+	Enabled     bool          `json:"enabled" bson:"enabled"`
+
+	// 运行配置（触发爬虫时）
+	RunType  string          `json:"run_type" bson:"run_type"`
+	NodeIds  []bson.ObjectId `json:"node_ids" bson:"node_ids"`
+	Priority int             `json:"priority" bson:"priority"`
+	Timeout  int             `json:"timeout" bson:"timeout"`
+
+	// 请求数据映射
+	Param string       `json:"param" bson:"param"` // 参数模版，{{data.id}} 替换为请求数据中的字段，为空则使用爬虫或定时任务的参数
+	Envs  []WebhookEnv `json:"envs" bson:"envs"`   // 环境变量映射
+
+	LastTriggerTs time.Time `json:"last_trigger_ts" bson:"last_trigger_ts"`
+
+	// 前端展示
+	SpiderName   string `json:"spider_name" bson:"-"`
+	ScheduleName string `json:"schedule_name" bson:"-"`
+
+	UserId   bson.ObjectId `json:"user_id" bson:"user_id"`
+	CreateTs time.Time     `json:"create_ts" bson:"create_ts"`
+	UpdateTs time.Time     `json:"update_ts" bson:"update_ts"`
+}
+
+func (w *Webhook) Save() error {
+	s, c := database.GetCol("webhooks")
+	defer s.Close()
+	w.UpdateTs = time.Now()
+	if err := c.UpdateId(w.Id, w); err != nil {
+		log.Errorf("update webhook error: %s", err.Error())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func (w *Webhook) Add() error {
+	s, c := database.GetCol("webhooks")
+	defer s.Close()
+	w.Id = bson.NewObjectId()
+	w.CreateTs = time.Now()
+	w.UpdateTs = time.Now()
+	if err := c.Insert(w); err != nil {
+		log.Errorf("add webhook error: %s", err.Error())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func (w *Webhook) Delete() error {
+	s, c := database.GetCol("webhooks")
+	defer s.Close()
+	if err := c.RemoveId(w.Id); err != nil {
+		log.Errorf("remove webhook error: %s", err.Error())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func GetWebhookList(filter interface{}) ([]Webhook, error) {
+	s, c := database.GetCol("webhooks")
+	defer s.Close()
+
+	var webhooks []Webhook
+	if err := c.Find(filter).Sort("-create_ts").All(&webhooks); err != nil {
+		debug.PrintStack()
+		return webhooks, err
+	}
+
+	for i, w := range webhooks {
+		if w.SpiderId.Valid() {
+			if spider, err := GetSpider(w.SpiderId); err == nil {
+				webhooks[i].SpiderName = spider.DisplayName
+			}
+		}
+		if w.ScheduleId.Valid() {
+			if schedule, err := GetSchedule(w.ScheduleId); err == nil {
+				webhooks[i].ScheduleName = schedule.Name
+			}
+		}
+	}
+	return webhooks, nil
+}
+
+func GetWebhook(id bson.ObjectId) (Webhook, error) {
+	s, c := database.GetCol("webhooks")
+	defer s.Close()
+
+	var w Webhook
+	if err := c.FindId(id).One(&w); err != nil {
+		if err != mgo.ErrNotFound {
+			log.Errorf("get webhook error: %s, id: %s", err.Error(), id.Hex())
+			debug.PrintStack()
+		}
+		return w, err
+	}
+	return w, nil
+}
+
+// 更新最近触发时间
+func UpdateWebhookLastTriggerTs(id bson.ObjectId, ts time.Time) error {
+	s, c := database.GetCol("webhooks")
+	defer s.Close()
+	return c.UpdateId(id, bson.M{"$max": bson.M{"last_trigger_ts": ts}})
+}
+
+// 删除爬虫或定时任务的 Web Hook
+func RemoveWebhooks(filter interface{}) error {
+	s, c := database.GetCol("webhooks")
+	defer s.Close()
+	if _, err := c.RemoveAll(filter); err != nil {
+		log.Errorf("remove webhooks error: %s", err.Error())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package model
+
+import (
+	"crawlab/database"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"runtime/debug"
+	"time"
+)
+
+// 工作流节点（一个爬虫）
+type WorkflowNode struct {
+	Key      string        `json:"key" bson:"key"`                             // 节点标识（工作流内唯一）
+	SpiderId bson.ObjectId `json:"spider_id" bson:"spider_id"`                 // 爬虫ID
+	NodeId   bson.ObjectId `json:"node_id,omitempty" bson:"node_id,omitempty"` // 运行节点（为空则随机）
+	Param    string        `json:"param" bson:"param"`                         // 参数
+}
+
+// 工作流依赖（有向边）
+type WorkflowEdge struct {
+	Source    string `json:"source" bson:"source"`       // 上游节点标识
+	Target    string `json:"target" bson:"target"`       // 下游节点标识
+	Condition string `json:"condition" bson:"condition"` // 触发条件：on-success/on-failure/always
+}
+
+type Workflow struct {
+	Id          bson.ObjectId  `json:"_id" bson:"_id"`
+	Name        string         `json:"name" bson:"name"`
+	Description string         `json:"description" bson:"description"`
+	Nodes       []WorkflowNode `json:"nodes" bson:"nodes"`
+	Edges       []WorkflowEdge `json:"edges" bson:"edges"`
+	Cron        string         `json:"cron" bson:"cron"`       // 定时触发（为空则只能手动触发）
+	Enabled     bool           `json:"enabled" bson:"enabled"` // 是否启用定时触发
+
+	UserId   bson.ObjectId `json:"user_id" bson:"user_id"`
+	CreateTs time.Time     `json:"create_ts" bson:"create_ts"`
+	UpdateTs time.Time     `json:"update_ts" bson:"update_ts"`
+}
+
+// 工作流运行记录
+type WorkflowRun struct {
+	Id             bson.ObjectId `json:"_id" bson:"_id"`
+	WorkflowId     bson.ObjectId `json:"workflow_id" bson:"workflow_id"`
+	TriggeredNodes []string      `json:"triggered_nodes" bson:"triggered_nodes"` // 已触发的节点标识
+	UserId         bson.ObjectId `json:"user_id" bson:"user_id"`
+	CreateTs       time.Time     `json:"create_ts" bson:"create_ts"`
+	UpdateTs       time.Time     `json:"update_ts" bson:"update_ts"`
+}
+
+// 获取节点
+func (w *Workflow) GetNode(key string) (WorkflowNode, bool) {
+	for _, n := range w.Nodes {
+		if n.Key == key {
+			return n, true
+		}
+	}
+	return WorkflowNode{}, false
+}
+
+// 获取节点的上游依赖
+func (w *Workflow) GetIncomingEdges(key string) (edges []WorkflowEdge) {
+	for _, e := range w.Edges {
+		if e.Target == key {
+			edges = append(edges, e)
+		}
+	}
+	return edges
+}
+
+// 获取节点的下游依赖
+func (w *Workflow) GetOutgoingEdges(key string) (edges []WorkflowEdge) {
+	for _, e := range w.Edges {
+		if e.Source == key {
+			edges = append(edges, e)
+		}
+	}
+	return edges
+}
+
+// 获取根节点（没有上游依赖的节点）
+func (w *Workflow) GetRootNodes() (nodes []WorkflowNode) {
+	for _, n := range w.Nodes {
+		if len(w.GetIncomingEdges(n.Key)) == 0 {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+func (w *Workflow) Save() error {
+	s, c := database.GetCol("workflows")
+	defer s.Close()
+	w.UpdateTs = time.Now()
+	if err := c.UpdateId(w.Id, w); err != nil {
+		log.Errorf("update workflow error: %s", err.Error())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func (w *Workflow) Add() error {
+	s, c := database.GetCol("workflows")
+	defer s.Close()
+	w.Id = bson.NewObjectId()
+	w.CreateTs = time.Now()
+	w.UpdateTs = time.Now()
+	if err := c.Insert(w); err != nil {
+		log.Errorf("add workflow error: %s", err.Error())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func (w *Workflow) Delete() error {
+	s, c := database.GetCol("workflows")
+	defer s.Close()
+	if err := c.RemoveId(w.Id); err != nil {
+		log.Errorf("remove workflow error: %s", err.Error())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func GetWorkflowList(filter interface{}) ([]Workflow, error) {
+	s, c := database.GetCol("workflows")
+	defer s.Close()
+
+	var workflows []Workflow
+	if err := c.Find(filter).Sort("-create_ts").All(&workflows); err != nil {
+		debug.PrintStack()
+		return workflows, err
+	}
+	return workflows, nil
+}
+
+func GetWorkflow(id bson.ObjectId) (Workflow, error) {
+	s, c := database.GetCol("workflows")
+	defer s.Close()
+
+	var w Workflow
+	if err := c.FindId(id).One(&w); err != nil {
+		if err != mgo.ErrNotFound {
+			log.Errorf("get workflow error: %s, id: %s", err.Error(), id.Hex())
+			debug.PrintStack()
+		}
+		return w, err
+	}
+	return w, nil
+}
+
+func AddWorkflowRun(run *WorkflowRun) error {
+	s, c := database.GetCol("workflow_runs")
+	defer s.Close()
+	run.Id = bson.NewObjectId()
+	run.TriggeredNodes = []string{}
+	run.CreateTs = time.Now()
+	run.UpdateTs = time.Now()
+	if err := c.Insert(run); err != nil {
+		log.Errorf("add workflow run error: %s", err.Error())
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+// 原子地标记运行记录中的节点为已触发，返回是否标记成功（未被其他上游抢先触发）
+func ClaimWorkflowRunNode(runId bson.ObjectId, key string) (bool, error) {
+	s, c := database.GetCol("workflow_runs")
+	defer s.Close()
+
+	selector := bson.M{
+		"_id":             runId,
+		"triggered_nodes": bson.M{"$ne": key},
+	}
+	update := bson.M{
+		"$push": bson.M{"triggered_nodes": key},
+		"$set":  bson.M{"update_ts": time.Now()},
+	}
+	if err := c.Update(selector, update); err != nil {
+		if err == mgo.ErrNotFound {
+			return false, nil
+		}
+		log.Errorf("claim workflow run node error: %s", err.Error())
+		debug.PrintStack()
+		return false, err
+	}
+	return true, nil
+}
+
+// 获取运行记录中某节点最新的任务
+func GetLatestWorkflowTask(runId bson.ObjectId, key string) (t Task, err error) {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+
+	query := bson.M{
+		"workflow_run_id":   runId,
+		"workflow_node_key": key,
+	}
+	if err := c.Find(query).Sort("-create_ts").One(&t); err != nil {
+		return t, err
+	}
+	return t, nil
+}
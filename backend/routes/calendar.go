@@ -0,0 +1,166 @@
+package routes
+
+import (
+	"crawlab/model"
+	"crawlab/services"
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo/bson"
+	"net/http"
+)
+
+// @Summary Get calendar list
+// @Description Get calendar list
+// @Tags calendar
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /calendars [get]
+func GetCalendarList(c *gin.Context) {
+	results, err := model.GetCalendarList(nil)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccessData(c, results)
+}
+
+// @Summary Get calendar
+// @Description Get calendar
+// @Tags calendar
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "calendar id"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /calendars/{id} [get]
+func GetCalendar(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+	result, err := model.GetCalendar(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccessData(c, result)
+}
+
+// @Summary Put calendar
+// @Description Put calendar
+// @Tags calendar
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param item body model.Calendar true "calendar item"
+// @Success 200 json string Response
+// @Failure 500 json string Response
+// @Router /calendars [put]
+func PutCalendar(c *gin.Context) {
+	var item model.Calendar
+	if err := c.ShouldBindJSON(&item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 校验日历
+	if err := services.ValidateCalendar(item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 加入用户ID
+	item.UserId = services.GetCurrentUserId(c)
+
+	if err := item.Add(); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccessData(c, item)
+}
+
+// @Summary Post calendar
+// @Description Post calendar
+// @Tags calendar
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "calendar id"
+// @Param item body model.Calendar true "calendar item"
+// @Success 200 json string Response
+// @Failure 500 json string Response
+// @Router /calendars/{id} [post]
+func PostCalendar(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+	cal, err := model.GetCalendar(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	var item model.Calendar
+	if err := c.ShouldBindJSON(&item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 校验日历
+	if err := services.ValidateCalendar(item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	item.Id = cal.Id
+	item.UserId = cal.UserId
+	item.CreateTs = cal.CreateTs
+	if err := item.Save(); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccess(c)
+}
+
+// @Summary Delete calendar
+// @Description Delete calendar
+// @Tags calendar
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "calendar id"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /calendars/{id} [delete]
+func DeleteCalendar(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+	cal, err := model.GetCalendar(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	// 被定时任务引用的日历不能删除
+	inUse, err := services.IsCalendarInUse(cal.Id)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	if inUse {
+		HandleErrorF(http.StatusBadRequest, c, "calendar is used by schedules")
+		return
+	}
+
+	if err := cal.Delete(); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccess(c)
+}
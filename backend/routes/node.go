@@ -27,6 +27,12 @@ func GetNodeList(c *gin.Context) {
 	//	nodes[i].IsMaster = services.IsMasterNode(node.Id.Hex())
 	//}
 
+	// 调度节点
+	leaderId, _ := services.GetLeaderNodeId()
+	for i, node := range nodes {
+		nodes[i].IsLeader = node.Id.Hex() == leaderId
+	}
+
 	c.JSON(http.StatusOK, Response{
 		Status:  "ok",
 		Message: "success",
@@ -54,6 +60,14 @@ func GetNode(c *gin.Context) {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
+
+	// 运行中任务数
+	result.RunningTaskCount, _ = result.GetRunningTaskCount()
+
+	// 调度节点
+	leaderId, _ := services.GetLeaderNodeId()
+	result.IsLeader = result.Id.Hex() == leaderId
+
 	c.JSON(http.StatusOK, Response{
 		Status:  "ok",
 		Message: "success",
@@ -102,6 +116,9 @@ func PostNode(c *gin.Context) {
 		return
 	}
 	newItem.Id = item.Id
+	newItem.Draining = item.Draining
+	newItem.DrainStartTs = item.DrainStartTs
+	newItem.DrainDeadline = item.DrainDeadline
 
 	if err := model.UpdateNode(bson.ObjectIdHex(id), newItem); err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
@@ -196,3 +213,89 @@ func DeleteNode(c *gin.Context) {
 		Message: "success",
 	})
 }
+
+// @Summary Drain node
+// @Description Stop the node from taking new tasks and let running tasks finish
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "With the bearer started"
+// @Param id path string true "node id"
+// @Param timeout body int false "deadline in seconds, running tasks are cancelled after it (0 for no deadline)"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /nodes/{id}/drain [post]
+func DrainNode(c *gin.Context) {
+	type RequestData struct {
+		Timeout int `json:"timeout"`
+	}
+
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+	var reqData RequestData
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&reqData); err != nil {
+			HandleError(http.StatusBadRequest, c, err)
+			return
+		}
+	}
+	if reqData.Timeout < 0 {
+		HandleErrorF(http.StatusBadRequest, c, "timeout should not be negative")
+		return
+	}
+
+	status, err := services.DrainNode(bson.ObjectIdHex(id), reqData.Timeout)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccessData(c, status)
+}
+
+// @Summary Get node drain status
+// @Description Get node drain progress
+// @Tags node
+// @Produce json
+// @Param Authorization header string true "With the bearer started"
+// @Param id path string true "node id"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /nodes/{id}/drain [get]
+func GetNodeDrainStatus(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+	status, err := services.GetNodeDrainStatus(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccessData(c, status)
+}
+
+// @Summary Resume node
+// @Description Stop draining the node and let it take new tasks again
+// @Tags node
+// @Produce json
+// @Param Authorization header string true "With the bearer started"
+// @Param id path string true "node id"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /nodes/{id}/drain [delete]
+func ResumeNode(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+	if err := services.ResumeNode(bson.ObjectIdHex(id)); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccess(c)
+}
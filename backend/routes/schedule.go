@@ -8,6 +8,8 @@ import (
 	"github.com/globalsign/mgo/bson"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"time"
 )
 
 // @Summary Get schedule list
@@ -29,6 +31,10 @@ func GetScheduleList(c *gin.Context) {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
+	for i := range results {
+		services.FillScheduleRunTs(&results[i])
+	}
+	services.FillScheduleListHealth(results)
 	HandleSuccessData(c, results)
 }
 
@@ -52,10 +58,157 @@ func GetSchedule(c *gin.Context) {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
+	services.FillScheduleRunTs(&result)
+	services.FillScheduleHealth(&result)
 
 	HandleSuccessData(c, result)
 }
 
+type ScheduleHistoryRequestData struct {
+	PageNum  int `form:"page_num"`
+	PageSize int `form:"page_size"`
+	Days     int `form:"days"`
+}
+
+// @Summary Get schedule run history
+// @Description Get tasks of a schedule with success rate, average runtime, average result count and last failure
+// @Tags schedule
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "schedule id"
+// @Param page_num query int false "page num"
+// @Param page_size query int false "page size"
+// @Param days query int false "only tasks created in recent days"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /schedules/{id}/history [get]
+func GetScheduleHistory(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+	data := ScheduleHistoryRequestData{}
+	if err := c.ShouldBindQuery(&data); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+	if data.PageNum == 0 {
+		data.PageNum = 1
+	}
+	if data.PageSize == 0 {
+		data.PageSize = 10
+	}
+
+	s, err := model.GetSchedule(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	history, err := services.GetScheduleRunHistory(s, data.PageNum, data.PageSize, data.Days)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccessData(c, history)
+}
+
+// @Summary Get schedule fires
+// @Description Get fire records of a schedule (triggered, skipped or error)
+// @Tags schedule
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "schedule id"
+// @Param page_num query int false "page num"
+// @Param page_size query int false "page size"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /schedules/{id}/fires [get]
+func GetScheduleFires(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+	data := ScheduleHistoryRequestData{}
+	if err := c.ShouldBindQuery(&data); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+	if data.PageNum == 0 {
+		data.PageNum = 1
+	}
+	if data.PageSize == 0 {
+		data.PageSize = 10
+	}
+
+	query := bson.M{"schedule_id": bson.ObjectIdHex(id)}
+	list, err := model.GetScheduleHistoryList(query, (data.PageNum-1)*data.PageSize, data.PageSize)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	total, err := model.GetScheduleHistoryTotal(query)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Status:  "ok",
+		Message: "success",
+		Total:   total,
+		Data:    list,
+	})
+}
+
+// @Summary Preview cron fire times
+// @Description Preview the next fire times of a cron expression
+// @Tags schedule
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param cron query string true "cron expression"
+// @Param timezone query string false "timezone"
+// @Param schedule_id query string false "schedule id used to resolve H fields (required when the expression has H fields)"
+// @Param n query int false "count of fire times"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /schedules-preview [get]
+func PreviewScheduleCron(c *gin.Context) {
+	spec := c.Query("cron")
+	if spec == "" {
+		HandleErrorF(http.StatusBadRequest, c, "cron is required")
+		return
+	}
+	n := 5
+	if c.Query("n") != "" {
+		value, err := strconv.Atoi(c.Query("n"))
+		if err != nil {
+			HandleErrorF(http.StatusBadRequest, c, "invalid n")
+			return
+		}
+		n = value
+	}
+
+	// H 字段的触发时间由定时任务ID决定，没有ID时无法得到实际的触发时间
+	scheduleId := c.Query("schedule_id")
+	if scheduleId != "" && !bson.IsObjectIdHex(scheduleId) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid schedule_id")
+		return
+	}
+	if scheduleId == "" && services.HasCronHashField(spec) {
+		HandleErrorF(http.StatusBadRequest, c, "schedule_id is required to preview H fields")
+		return
+	}
+
+	results, err := services.PreviewCron(spec, c.Query("timezone"), scheduleId, n, time.Now())
+	if err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+	HandleSuccessData(c, results)
+}
+
 // @Summary Post schedule
 // @Description Post schedule
 // @Tags schedule
@@ -81,8 +234,16 @@ func PostSchedule(c *gin.Context) {
 	}
 
 	// 验证cron表达式
-	if err := services.ParserCron(newItem.Cron); err != nil {
-		HandleError(http.StatusInternalServerError, c, err)
+	if services.IsCronSchedule(newItem) {
+		if err := services.ParserCron(newItem.Cron); err != nil {
+			HandleError(http.StatusInternalServerError, c, err)
+			return
+		}
+	}
+
+	// 验证定时任务设置
+	if err := services.ValidateSchedule(newItem); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
 		return
 	}
 
@@ -122,8 +283,16 @@ func PutSchedule(c *gin.Context) {
 	}
 
 	// 验证cron表达式
-	if err := services.ParserCron(item.Cron); err != nil {
-		HandleError(http.StatusInternalServerError, c, err)
+	if services.IsCronSchedule(item) {
+		if err := services.ParserCron(item.Cron); err != nil {
+			HandleError(http.StatusInternalServerError, c, err)
+			return
+		}
+	}
+
+	// 验证定时任务设置
+	if err := services.ValidateSchedule(item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
 		return
 	}
 
@@ -232,10 +401,16 @@ func PutBatchSchedules(c *gin.Context) {
 
 	for _, s := range schedules {
 		// 验证cron表达式
-		if err := services.ParserCron(s.Cron); err != nil {
-			log.Errorf("parse cron error: " + err.Error())
-			debug.PrintStack()
-			HandleError(http.StatusInternalServerError, c, err)
+		if services.IsCronSchedule(s) {
+			if err := services.ParserCron(s.Cron); err != nil {
+				log.Errorf("parse cron error: " + err.Error())
+				debug.PrintStack()
+				HandleError(http.StatusInternalServerError, c, err)
+				return
+			}
+		}
+		if err := services.ValidateSchedule(s); err != nil {
+			HandleError(http.StatusBadRequest, c, err)
 			return
 		}
 
@@ -304,8 +479,16 @@ func SetEnabledSchedules(c *gin.Context) {
 			HandleError(http.StatusInternalServerError, c, err)
 			return
 		}
-		s.Enabled = reqBody.Enabled
-		if err := s.Save(); err != nil {
+		if reqBody.Enabled && !s.Enabled {
+			// 不补跑禁用期间的触发，重新计算运行次数
+			if err := model.ResetScheduleRunState(id); err != nil {
+				log.Errorf("save schedule error: " + err.Error())
+				debug.PrintStack()
+				HandleError(http.StatusInternalServerError, c, err)
+				return
+			}
+		}
+		if err := model.UpdateScheduleStatus(id, s.Status, reqBody.Enabled); err != nil {
 			log.Errorf("save schedule error: " + err.Error())
 			debug.PrintStack()
 			HandleError(http.StatusInternalServerError, c, err)
@@ -149,6 +149,9 @@ func GetSpider(c *gin.Context) {
 		return
 	}
 
+	// 运行中任务数
+	spider.RunningTaskCount, _ = spider.GetRunningTaskCount()
+
 	c.JSON(http.StatusOK, Response{
 		Status:  "ok",
 		Message: "success",
@@ -181,6 +184,12 @@ func PostSpider(c *gin.Context) {
 		return
 	}
 
+	// 校验爬虫设置
+	if err := services.ValidateSpider(item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
 	// UserId
 	if !item.UserId.Valid() {
 		item.UserId = bson.ObjectIdHex(constants.ObjectIdNull)
@@ -271,6 +280,12 @@ func PutSpider(c *gin.Context) {
 		return
 	}
 
+	// 校验爬虫设置
+	if err := services.ValidateSpider(spider); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
 	// 判断爬虫是否存在
 	if spider := model.GetSpiderByName(spider.Name); spider.Name != "" {
 		HandleErrorF(http.StatusBadRequest, c, fmt.Sprintf("spider for '%s' already exists", spider.Name))
@@ -7,19 +7,27 @@ import (
 	"crawlab/services"
 	"crawlab/utils"
 	"encoding/csv"
+	"encoding/json"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/globalsign/mgo/bson"
+	uuid "github.com/satori/go.uuid"
 	"net/http"
+	"strconv"
 )
 
 type TaskListRequestData struct {
-	PageNum    int    `form:"page_num"`
-	PageSize   int    `form:"page_size"`
-	NodeId     string `form:"node_id"`
-	SpiderId   string `form:"spider_id"`
-	ScheduleId string `form:"schedule_id"`
-	Status     string `form:"status"`
-	Type       string `form:"type"`
+	PageNum       int    `form:"page_num"`
+	PageSize      int    `form:"page_size"`
+	NodeId        string `form:"node_id"`
+	SpiderId      string `form:"spider_id"`
+	ScheduleId    string `form:"schedule_id"`
+	Status        string `form:"status"`
+	Type          string `form:"type"`
+	Priority      int    `form:"priority"`
+	WorkflowId    string `form:"workflow_id"`
+	WorkflowRunId string `form:"workflow_run_id"`
+	BatchId       string `form:"batch_id"`
 }
 
 type TaskResultsRequestData struct {
@@ -68,6 +76,18 @@ func GetTaskList(c *gin.Context) {
 	if data.Type != "" {
 		query["type"] = data.Type
 	}
+	if data.Priority != 0 {
+		query["priority"] = data.Priority
+	}
+	if bson.IsObjectIdHex(data.WorkflowId) {
+		query["workflow_id"] = bson.ObjectIdHex(data.WorkflowId)
+	}
+	if bson.IsObjectIdHex(data.WorkflowRunId) {
+		query["workflow_run_id"] = bson.ObjectIdHex(data.WorkflowRunId)
+	}
+	if data.BatchId != "" {
+		query["batch_id"] = data.BatchId
+	}
 
 	// 获取校验
 	query = services.GetAuthQuery(query, c)
@@ -127,6 +147,8 @@ func PutTask(c *gin.Context) {
 		RunType  string          `json:"run_type"`
 		NodeIds  []bson.ObjectId `json:"node_ids"`
 		Param    string          `json:"param"`
+		Priority int             `json:"priority"`
+		Timeout  int             `json:"timeout"`
 	}
 
 	// 绑定数据
@@ -151,6 +173,8 @@ func PutTask(c *gin.Context) {
 				SpiderId:   reqBody.SpiderId,
 				NodeId:     node.Id,
 				Param:      reqBody.Param,
+				Priority:   reqBody.Priority,
+				Timeout:    reqBody.Timeout,
 				UserId:     services.GetCurrentUserId(c),
 				RunType:    constants.RunTypeAllNodes,
 				ScheduleId: bson.ObjectIdHex(constants.ObjectIdNull),
@@ -170,6 +194,8 @@ func PutTask(c *gin.Context) {
 		t := model.Task{
 			SpiderId:   reqBody.SpiderId,
 			Param:      reqBody.Param,
+			Priority:   reqBody.Priority,
+			Timeout:    reqBody.Timeout,
 			UserId:     services.GetCurrentUserId(c),
 			RunType:    constants.RunTypeRandom,
 			ScheduleId: bson.ObjectIdHex(constants.ObjectIdNull),
@@ -188,6 +214,8 @@ func PutTask(c *gin.Context) {
 				SpiderId:   reqBody.SpiderId,
 				NodeId:     nodeId,
 				Param:      reqBody.Param,
+				Priority:   reqBody.Priority,
+				Timeout:    reqBody.Timeout,
 				UserId:     services.GetCurrentUserId(c),
 				RunType:    constants.RunTypeSelectedNodes,
 				ScheduleId: bson.ObjectIdHex(constants.ObjectIdNull),
@@ -215,73 +243,41 @@ func PutBatchTasks(c *gin.Context) {
 		HandleError(http.StatusOK, c, err)
 		return
 	}
-	var taskIds []string
-	for _, t := range tasks {
-		if t.RunType == constants.RunTypeAllNodes {
-			// 所有节点
-			nodes, err := model.GetNodeList(nil)
-			if err != nil {
-				HandleError(http.StatusInternalServerError, c, err)
-				return
-			}
-			for _, node := range nodes {
-				t := model.Task{
-					SpiderId:   t.SpiderId,
-					NodeId:     node.Id,
-					Param:      t.Param,
-					UserId:     services.GetCurrentUserId(c),
-					RunType:    constants.RunTypeAllNodes,
-					ScheduleId: bson.ObjectIdHex(constants.ObjectIdNull),
-					Type:       constants.TaskTypeSpider,
-				}
-
-				id, err := services.AddTask(t)
-				if err != nil {
-					HandleError(http.StatusInternalServerError, c, err)
-					return
-				}
-				taskIds = append(taskIds, id)
-			}
-		} else if t.RunType == constants.RunTypeRandom {
-			// 随机
-			t := model.Task{
-				SpiderId:   t.SpiderId,
-				Param:      t.Param,
-				UserId:     services.GetCurrentUserId(c),
-				RunType:    constants.RunTypeRandom,
-				ScheduleId: bson.ObjectIdHex(constants.ObjectIdNull),
-				Type:       constants.TaskTypeSpider,
-			}
-			id, err := services.AddTask(t)
-			if err != nil {
-				HandleError(http.StatusInternalServerError, c, err)
+	// 先校验所有任务的运行方式，避免部分任务已创建后才出错
+	nodeIdsList := make([][]bson.ObjectId, len(tasks))
+	for i, t := range tasks {
+		var nodeIds []bson.ObjectId
+		for _, nodeId := range t.NodeIds {
+			if !bson.IsObjectIdHex(nodeId) {
+				HandleErrorF(http.StatusBadRequest, c, "invalid node_ids")
 				return
 			}
-			taskIds = append(taskIds, id)
-		} else if t.RunType == constants.RunTypeSelectedNodes {
-			// 指定节点
-			for _, nodeId := range t.NodeIds {
-				t := model.Task{
-					SpiderId:   t.SpiderId,
-					NodeId:     bson.ObjectIdHex(nodeId),
-					Param:      t.Param,
-					UserId:     services.GetCurrentUserId(c),
-					RunType:    constants.RunTypeSelectedNodes,
-					ScheduleId: bson.ObjectIdHex(constants.ObjectIdNull),
-					Type:       constants.TaskTypeSpider,
-				}
-
-				id, err := services.AddTask(t)
-				if err != nil {
-					HandleError(http.StatusInternalServerError, c, err)
-					return
-				}
-				taskIds = append(taskIds, id)
-			}
-		} else {
-			HandleErrorF(http.StatusInternalServerError, c, "invalid run_type")
+			nodeIds = append(nodeIds, bson.ObjectIdHex(nodeId))
+		}
+		if err := services.ValidateTaskRunType(t.RunType, nodeIds); err != nil {
+			HandleError(http.StatusBadRequest, c, err)
 			return
 		}
+		nodeIdsList[i] = nodeIds
+	}
+
+	var taskIds []string
+	for i, t := range tasks {
+		task := model.Task{
+			SpiderId:   t.SpiderId,
+			Param:      t.Param,
+			Priority:   t.Priority,
+			Timeout:    t.Timeout,
+			UserId:     services.GetCurrentUserId(c),
+			ScheduleId: bson.ObjectIdHex(constants.ObjectIdNull),
+			Type:       constants.TaskTypeSpider,
+		}
+		ids, err := services.AddTasksByRunType(task, t.RunType, nodeIdsList[i])
+		if err != nil {
+			HandleError(http.StatusInternalServerError, c, err)
+			return
+		}
+		taskIds = append(taskIds, ids...)
 	}
 
 	c.JSON(http.StatusOK, Response{
@@ -291,6 +287,133 @@ func PutBatchTasks(c *gin.Context) {
 	})
 }
 
+// @Summary Put matrix tasks
+// @Description Expand a param template over a matrix of values into a batch of tasks
+// @Tags task
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /tasks/matrix [put]
+func PutMatrixTasks(c *gin.Context) {
+	type ReqBody struct {
+		SpiderId bson.ObjectId       `json:"spider_id"`
+		RunType  string              `json:"run_type"`
+		NodeIds  []bson.ObjectId     `json:"node_ids"`
+		Param    string              `json:"param"`
+		Envs     []model.Env         `json:"envs"`
+		Matrix   map[string][]string `json:"matrix"`
+		Priority int                 `json:"priority"`
+		Timeout  int                 `json:"timeout"`
+	}
+
+	// 绑定数据
+	var reqBody ReqBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 校验运行方式
+	if err := services.ValidateTaskRunType(reqBody.RunType, reqBody.NodeIds); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 展开参数矩阵
+	items, err := services.ExpandParamMatrix(reqBody.Param, reqBody.Envs, reqBody.Matrix)
+	if err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 批量任务ID
+	batchId := uuid.NewV4().String()
+
+	// 任务ID
+	var taskIds []string
+
+	for _, item := range items {
+		t := model.Task{
+			SpiderId:   reqBody.SpiderId,
+			Param:      item.Param,
+			Envs:       item.Envs,
+			Priority:   reqBody.Priority,
+			Timeout:    reqBody.Timeout,
+			BatchId:    batchId,
+			UserId:     services.GetCurrentUserId(c),
+			ScheduleId: bson.ObjectIdHex(constants.ObjectIdNull),
+			Type:       constants.TaskTypeSpider,
+		}
+		ids, err := services.AddTasksByRunType(t, reqBody.RunType, reqBody.NodeIds)
+		if err != nil {
+			HandleError(http.StatusInternalServerError, c, err)
+			return
+		}
+		taskIds = append(taskIds, ids...)
+	}
+
+	HandleSuccessData(c, gin.H{
+		"batch_id": batchId,
+		"task_ids": taskIds,
+	})
+}
+
+// @Summary Get task batch
+// @Description Get task batch summary (counts per status, total results)
+// @Tags task
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "batch id"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /task-batches/{id} [get]
+func GetTaskBatch(c *gin.Context) {
+	id := c.Param("id")
+	summary, err := model.GetTaskBatchSummary(id)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccessData(c, summary)
+}
+
+// @Summary Cancel task batch
+// @Description Cancel pending and running tasks of a batch
+// @Tags task
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "batch id"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /task-batches/{id}/cancel [post]
+func CancelTaskBatch(c *gin.Context) {
+	id := c.Param("id")
+	if err := services.CancelTaskBatch(id); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccess(c)
+}
+
+// @Summary Restart task batch
+// @Description Restart unsuccessful tasks of a batch
+// @Tags task
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "batch id"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /task-batches/{id}/restart [post]
+func RestartTaskBatch(c *gin.Context) {
+	id := c.Param("id")
+	if err := services.RestartTaskBatch(id, services.GetCurrentUserId(c)); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccess(c)
+}
+
 // @Summary Delete task
 // @Description Delete task
 // @Tags task
@@ -406,12 +529,35 @@ func RestartSelectedTask(c *gin.Context) {
 	HandleSuccess(c)
 }
 
+// @Summary Reconcile task queues
+// @Description Re-enqueue pending tasks missing from task queues and mark stale pending tasks (admin only)
+// @Tags task
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Success 200 json string Response
+// @Failure 403 json string Response
+// @Router /tasks-reconcile [post]
+func ReconcileTaskQueues(c *gin.Context) {
+	if services.GetCurrentUser(c).Role != constants.RoleAdmin {
+		HandleErrorF(http.StatusForbidden, c, "only admin can reconcile task queues")
+		return
+	}
+	result, err := services.ReconcileTaskQueues()
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccessData(c, result)
+}
+
 // @Summary Get task log
 // @Description Get task log
 // @Tags task
 // @Produce json
 // @Param Authorization header string true "Authorization token"
 // @Param id path string true "task id"
+// @Param level query string false "log levels separated by comma, e.g. warning,error"
+// @Param stream query string false "stdout or stderr"
 // @Success 200 json string Response
 // @Failure 400 json string Response
 // @Router /tasks/{id}/log [delete]
@@ -420,6 +566,8 @@ func GetTaskLog(c *gin.Context) {
 		PageNum  int    `form:"page_num"`
 		PageSize int    `form:"page_size"`
 		Keyword  string `form:"keyword"`
+		Level    string `form:"level"`
+		Stream   string `form:"stream"`
 	}
 	id := c.Param("id")
 	var reqData RequestData
@@ -427,7 +575,19 @@ func GetTaskLog(c *gin.Context) {
 		HandleErrorF(http.StatusBadRequest, c, "invalid request")
 		return
 	}
-	logItems, logTotal, err := services.GetTaskLog(id, reqData.Keyword, reqData.PageNum, reqData.PageSize)
+
+	// 日志级别及来源
+	levels, err := services.ParseLogLevels(reqData.Level)
+	if err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+	if reqData.Stream != "" && reqData.Stream != constants.LogStreamStdout && reqData.Stream != constants.LogStreamStderr {
+		HandleErrorF(http.StatusBadRequest, c, "invalid stream")
+		return
+	}
+
+	logItems, logTotal, err := services.GetTaskLog(id, reqData.Keyword, levels, reqData.Stream, reqData.PageNum, reqData.PageSize)
 	if err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
@@ -440,6 +600,70 @@ func GetTaskLog(c *gin.Context) {
 	})
 }
 
+// @Summary Stream task log
+// @Description Stream task log in real time with Server-Sent Events, each "log" event carries a list of log items and its id is the last seq.
+// @Description Resumes after the seq given by the "seq" query or the Last-Event-ID header, an "end" event is sent when the task is finished.
+// @Tags task
+// @Produce text/event-stream
+// @Param Authorization header string false "Authorization token"
+// @Param token query string false "Authorization token, for EventSource clients that cannot set headers"
+// @Param id path string true "task id"
+// @Param seq query int false "resume after seq"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /tasks/{id}/log/stream [get]
+func StreamTaskLog(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := model.GetTask(id); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	// 断线重连时从上一次收到的 seq 之后继续
+	seqStr := c.Query("seq")
+	if seqStr == "" {
+		seqStr = c.GetHeader("Last-Event-ID")
+	}
+	var seq int64
+	if seqStr != "" {
+		value, err := strconv.ParseInt(seqStr, 10, 64)
+		if err != nil {
+			HandleErrorF(http.StatusBadRequest, c, "invalid seq")
+			return
+		}
+		seq = value
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	ctx := c.Request.Context()
+	err := services.StreamTaskLog(ctx, id, seq, func(items []model.LogItem) error {
+		if len(items) == 0 {
+			// 心跳
+			_, _ = fmt.Fprint(c.Writer, ": ping\n\n")
+		} else {
+			data, err := json.Marshal(items)
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(c.Writer, "id: %d\nevent: log\ndata: %s\n\n", items[len(items)-1].Seq, data)
+		}
+		c.Writer.Flush()
+		return ctx.Err()
+	})
+	if err != nil {
+		_, _ = fmt.Fprintf(c.Writer, "event: error\ndata: %s\n\n", err.Error())
+	} else {
+		_, _ = fmt.Fprint(c.Writer, "event: end\ndata: {}\n\n")
+	}
+	c.Writer.Flush()
+}
+
 // @Summary Get task error log
 // @Description Get task error log
 // @Tags task
@@ -464,6 +688,25 @@ func GetTaskErrorLog(c *gin.Context) {
 	})
 }
 
+// @Summary Get task metrics
+// @Description Get task resource usage (cpu, memory, io) summary and time series
+// @Tags task
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "task id"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /tasks/{id}/metrics [get]
+func GetTaskMetrics(c *gin.Context) {
+	id := c.Param("id")
+	data, err := services.GetTaskMetrics(id)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccessData(c, data)
+}
+
 // @Summary Get task list
 // @Description Get task list
 // @Tags task
@@ -619,3 +862,29 @@ func RestartTask(c *gin.Context) {
 	}
 	HandleSuccess(c)
 }
+
+// @Summary Update task priority
+// @Description Update priority of a pending task
+// @Tags task
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "task id"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /tasks/{id}/priority [post]
+func PostTaskPriority(c *gin.Context) {
+	type ReqBody struct {
+		Priority int `json:"priority"`
+	}
+	id := c.Param("id")
+	var reqBody ReqBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+	if err := services.UpdateTaskPriority(id, reqBody.Priority); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccess(c)
+}
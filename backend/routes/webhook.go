@@ -0,0 +1,317 @@
+package routes
+
+import (
+	"crawlab/model"
+	"crawlab/services"
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo/bson"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// @Summary Get webhook list
+// @Description Get webhook list
+// @Tags webhook
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /webhooks [get]
+func GetWebhookList(c *gin.Context) {
+	query := services.GetAuthQuery(bson.M{}, c)
+	results, err := model.GetWebhookList(query)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	// 列表中不返回密钥
+	for i := range results {
+		results[i].Secret = ""
+	}
+	HandleSuccessData(c, results)
+}
+
+// @Summary Get webhook
+// @Description Get webhook
+// @Tags webhook
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "webhook id"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /webhooks/{id} [get]
+func GetWebhook(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+	result, err := model.GetWebhook(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	// 密钥只返回给创建者和管理员
+	if !services.HasAuthAccess(result.UserId, c) {
+		HandleErrorF(http.StatusForbidden, c, "no access to the webhook")
+		return
+	}
+	HandleSuccessData(c, result)
+}
+
+// @Summary Put webhook
+// @Description Put webhook, the secret is generated
+// @Tags webhook
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param item body model.Webhook true "webhook item"
+// @Success 200 json string Response
+// @Failure 500 json string Response
+// @Router /webhooks [put]
+func PutWebhook(c *gin.Context) {
+	var item model.Webhook
+	if err := c.ShouldBindJSON(&item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 校验 Web Hook
+	if err := services.ValidateWebhook(item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+	if !hasWebhookTargetAccess(item, c) {
+		HandleErrorF(http.StatusForbidden, c, "no access to the spider or schedule")
+		return
+	}
+
+	// 生成密钥
+	secret, err := services.GenerateWebhookSecret()
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	item.Secret = secret
+
+	// 加入用户ID
+	item.UserId = services.GetCurrentUserId(c)
+
+	if err := item.Add(); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccessData(c, item)
+}
+
+// @Summary Post webhook
+// @Description Post webhook
+// @Tags webhook
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "webhook id"
+// @Param item body model.Webhook true "webhook item"
+// @Success 200 json string Response
+// @Failure 500 json string Response
+// @Router /webhooks/{id} [post]
+func PostWebhook(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+	w, err := model.GetWebhook(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	if !services.HasAuthAccess(w.UserId, c) {
+		HandleErrorF(http.StatusForbidden, c, "no access to the webhook")
+		return
+	}
+
+	var item model.Webhook
+	if err := c.ShouldBindJSON(&item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 校验 Web Hook
+	if err := services.ValidateWebhook(item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+	if !hasWebhookTargetAccess(item, c) {
+		HandleErrorF(http.StatusForbidden, c, "no access to the spider or schedule")
+		return
+	}
+
+	// 密钥只能重新生成
+	item.Id = w.Id
+	item.Secret = w.Secret
+	item.LastTriggerTs = w.LastTriggerTs
+	item.UserId = w.UserId
+	item.CreateTs = w.CreateTs
+	if err := item.Save(); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccess(c)
+}
+
+// @Summary Delete webhook
+// @Description Delete webhook
+// @Tags webhook
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "webhook id"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /webhooks/{id} [delete]
+func DeleteWebhook(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+	w, err := model.GetWebhook(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	if !services.HasAuthAccess(w.UserId, c) {
+		HandleErrorF(http.StatusForbidden, c, "no access to the webhook")
+		return
+	}
+	if err := w.Delete(); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccess(c)
+}
+
+// @Summary Regenerate webhook secret
+// @Description Regenerate webhook secret, requests signed with the old secret are rejected
+// @Tags webhook
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "webhook id"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /webhooks/{id}/secret [post]
+func PostWebhookSecret(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+	w, err := model.GetWebhook(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	if !services.HasAuthAccess(w.UserId, c) {
+		HandleErrorF(http.StatusForbidden, c, "no access to the webhook")
+		return
+	}
+
+	secret, err := services.GenerateWebhookSecret()
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	w.Secret = secret
+	if err := w.Save(); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccessData(c, w)
+}
+
+// 当前用户是否可以访问 Web Hook 触发的爬虫或定时任务
+func hasWebhookTargetAccess(w model.Webhook, c *gin.Context) bool {
+	if w.ScheduleId.Valid() {
+		s, err := model.GetSchedule(w.ScheduleId)
+		if err != nil || !services.HasAuthAccess(s.UserId, c) {
+			return false
+		}
+	}
+	if w.SpiderId.Valid() {
+		spider, err := model.GetSpider(w.SpiderId)
+		if err != nil || !services.HasAuthAccess(spider.UserId, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// @Summary Trigger webhook
+// @Description Trigger webhook by external systems, the JSON payload is mapped into task param and env vars.
+// @Description The request should be signed: X-Crawlab-Signature = ComputeHmacSha256(X-Crawlab-Timestamp + "." + body, secret)
+// @Description Each signature is accepted only once.
+// @Tags webhook
+// @Accept json
+// @Produce json
+// @Param id path string true "webhook id"
+// @Param X-Crawlab-Timestamp header string true "unix timestamp (seconds)"
+// @Param X-Crawlab-Signature header string true "request signature"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /webhooks/{id}/trigger [post]
+func TriggerWebhook(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusNotFound, c, "webhook not found")
+		return
+	}
+	w, err := model.GetWebhook(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleErrorF(http.StatusNotFound, c, "webhook not found")
+		return
+	}
+	if !w.Enabled {
+		HandleErrorF(http.StatusForbidden, c, "webhook is disabled")
+		return
+	}
+
+	// 请求体
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, services.MaxWebhookPayloadSize+1))
+	if err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+	if len(body) > services.MaxWebhookPayloadSize {
+		HandleErrorF(http.StatusRequestEntityTooLarge, c, "payload is too large")
+		return
+	}
+
+	// 校验签名
+	timestamp := c.GetHeader(services.WebhookTimestampHeader)
+	signature := c.GetHeader(services.WebhookSignatureHeader)
+	if err := services.VerifyWebhookSignature(w, timestamp, signature, body, time.Now()); err != nil {
+		HandleError(http.StatusUnauthorized, c, err)
+		return
+	}
+	if err := services.CheckWebhookReplay(w, signature); err != nil {
+		HandleError(http.StatusUnauthorized, c, err)
+		return
+	}
+
+	// 请求数据
+	if _, err := services.ParseWebhookPayload(body); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	taskIds, err := services.TriggerWebhook(w, body)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccessData(c, taskIds)
+}
@@ -0,0 +1,236 @@
+package routes
+
+import (
+	"crawlab/model"
+	"crawlab/services"
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo/bson"
+	"net/http"
+)
+
+// @Summary Get workflow list
+// @Description Get workflow list
+// @Tags workflow
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /workflows [get]
+func GetWorkflowList(c *gin.Context) {
+	query := bson.M{}
+
+	// 获取校验
+	query = services.GetAuthQuery(query, c)
+
+	results, err := model.GetWorkflowList(query)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccessData(c, results)
+}
+
+// @Summary Get workflow
+// @Description Get workflow
+// @Tags workflow
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "workflow id"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /workflows/{id} [get]
+func GetWorkflow(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+	result, err := model.GetWorkflow(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	if !services.HasAuthAccess(result.UserId, c) {
+		HandleErrorF(http.StatusForbidden, c, "no access to the workflow")
+		return
+	}
+	HandleSuccessData(c, result)
+}
+
+// @Summary Put workflow
+// @Description Put workflow
+// @Tags workflow
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param item body model.Workflow true "workflow item"
+// @Success 200 json string Response
+// @Failure 500 json string Response
+// @Router /workflows [put]
+func PutWorkflow(c *gin.Context) {
+	var item model.Workflow
+	if err := c.ShouldBindJSON(&item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 校验工作流
+	if err := services.ValidateWorkflow(item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+	if item.Cron != "" {
+		if err := services.ParserCron(item.Cron); err != nil {
+			HandleError(http.StatusBadRequest, c, err)
+			return
+		}
+	}
+
+	// 加入用户ID
+	item.UserId = services.GetCurrentUserId(c)
+
+	if err := item.Add(); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	// 更新定时任务
+	if err := services.Sched.Update(); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	HandleSuccessData(c, item)
+}
+
+// @Summary Post workflow
+// @Description Post workflow
+// @Tags workflow
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "workflow id"
+// @Param item body model.Workflow true "workflow item"
+// @Success 200 json string Response
+// @Failure 500 json string Response
+// @Router /workflows/{id} [post]
+func PostWorkflow(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+	w, err := model.GetWorkflow(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	if !services.HasAuthAccess(w.UserId, c) {
+		HandleErrorF(http.StatusForbidden, c, "no access to the workflow")
+		return
+	}
+
+	var item model.Workflow
+	if err := c.ShouldBindJSON(&item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 校验工作流
+	if err := services.ValidateWorkflow(item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+	if item.Cron != "" {
+		if err := services.ParserCron(item.Cron); err != nil {
+			HandleError(http.StatusBadRequest, c, err)
+			return
+		}
+	}
+
+	item.Id = w.Id
+	item.UserId = w.UserId
+	item.CreateTs = w.CreateTs
+	if err := item.Save(); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	// 更新定时任务
+	if err := services.Sched.Update(); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	HandleSuccess(c)
+}
+
+// @Summary Delete workflow
+// @Description Delete workflow
+// @Tags workflow
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "workflow id"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /workflows/{id} [delete]
+func DeleteWorkflow(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+	w, err := model.GetWorkflow(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	if !services.HasAuthAccess(w.UserId, c) {
+		HandleErrorF(http.StatusForbidden, c, "no access to the workflow")
+		return
+	}
+	if err := w.Delete(); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	// 更新定时任务
+	if err := services.Sched.Update(); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	HandleSuccess(c)
+}
+
+// @Summary Run workflow
+// @Description Run workflow manually
+// @Tags workflow
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path string true "workflow id"
+// @Success 200 json string Response
+// @Failure 400 json string Response
+// @Router /workflows/{id}/run [post]
+func RunWorkflow(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+	w, err := model.GetWorkflow(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	if !services.HasAuthAccess(w.UserId, c) {
+		HandleErrorF(http.StatusForbidden, c, "no access to the workflow")
+		return
+	}
+	runId, err := services.RunWorkflow(w.Id, services.GetCurrentUserId(c))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	HandleSuccessData(c, runId)
+}
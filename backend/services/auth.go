@@ -18,3 +18,8 @@ func GetAuthQuery(query bson.M, c *gin.Context) bson.M {
 	}
 }
 
+// 当前用户是否可以访问该用户的数据（管理员或本人）
+func HasAuthAccess(userId bson.ObjectId, c *gin.Context) bool {
+	user := GetCurrentUser(c)
+	return user.Role == constants.RoleAdmin || user.Id == userId
+}
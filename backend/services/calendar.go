@@ -0,0 +1,149 @@
+package services
+
+import (
+	"crawlab/model"
+	"errors"
+	"fmt"
+	"github.com/globalsign/mgo/bson"
+	"time"
+)
+
+// 解析 HH:MM，返回当天的分钟数
+func parseCalendarClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("invalid time: %s", value))
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// 校验日历
+func ValidateCalendar(cal model.Calendar) error {
+	if cal.Name == "" {
+		return errors.New("name is required")
+	}
+	if cal.Timezone != "" {
+		if _, err := time.LoadLocation(cal.Timezone); err != nil {
+			return errors.New(fmt.Sprintf("invalid timezone: %s", cal.Timezone))
+		}
+	}
+	for _, date := range cal.Dates {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return errors.New(fmt.Sprintf("invalid date: %s", date))
+		}
+	}
+	for _, w := range cal.Windows {
+		for _, weekday := range w.Weekdays {
+			if weekday < 0 || weekday > 6 {
+				return errors.New(fmt.Sprintf("invalid weekday: %d", weekday))
+			}
+		}
+		start, err := parseCalendarClock(w.Start)
+		if err != nil {
+			return err
+		}
+		end, err := parseCalendarClock(w.End)
+		if err != nil {
+			return err
+		}
+		if start == end {
+			return errors.New(fmt.Sprintf("empty window: %s-%s", w.Start, w.End))
+		}
+	}
+	return nil
+}
+
+// 时间窗口是否包含星期几，未设置星期则每天都包含
+func calendarWindowHasWeekday(w model.CalendarWindow, weekday int) bool {
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+	for _, d := range w.Weekdays {
+		if d == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断时间是否在日历中（日期或周期性时间窗口），loc 为日历未设置时区时使用的时区
+// 返回命中的原因
+func IsTimeInCalendar(cal model.Calendar, t time.Time, loc *time.Location) (bool, string) {
+	if cal.Timezone != "" {
+		if calLoc, err := time.LoadLocation(cal.Timezone); err == nil {
+			loc = calLoc
+		}
+	}
+	local := t.In(loc)
+
+	// 日期
+	date := local.Format("2006-01-02")
+	for _, d := range cal.Dates {
+		if d == date {
+			return true, fmt.Sprintf("date %s is excluded by calendar %s", date, cal.Name)
+		}
+	}
+
+	// 周期性时间窗口
+	minutes := local.Hour()*60 + local.Minute()
+	weekday := int(local.Weekday())
+	for _, w := range cal.Windows {
+		start, err := parseCalendarClock(w.Start)
+		if err != nil {
+			continue
+		}
+		end, err := parseCalendarClock(w.End)
+		if err != nil {
+			continue
+		}
+
+		var in bool
+		if start < end {
+			in = calendarWindowHasWeekday(w, weekday) && minutes >= start && minutes < end
+		} else {
+			// 跨过午夜的窗口，星期以开始时间所在的日期为准
+			in = (calendarWindowHasWeekday(w, weekday) && minutes >= start) ||
+				(calendarWindowHasWeekday(w, (weekday+6)%7) && minutes < end)
+		}
+		if in {
+			return true, fmt.Sprintf("window %s-%s is excluded by calendar %s", w.Start, w.End, cal.Name)
+		}
+	}
+	return false, ""
+}
+
+// 检查定时任务的触发时间是否被排除日历排除，日历未设置时区时使用定时任务的时区
+func CheckScheduleCalendars(s model.Schedule, t time.Time) (bool, string, error) {
+	if len(s.CalendarIds) == 0 {
+		return false, "", nil
+	}
+
+	loc := time.Local
+	if s.Timezone != "" {
+		scheduleLoc, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return false, "", err
+		}
+		loc = scheduleLoc
+	}
+
+	for _, id := range s.CalendarIds {
+		cal, err := model.GetCalendar(id)
+		if err != nil {
+			return false, "", errors.New(fmt.Sprintf("get calendar (id: %s) error: %s", id.Hex(), err.Error()))
+		}
+		if excluded, reason := IsTimeInCalendar(cal, t, loc); excluded {
+			return true, reason, nil
+		}
+	}
+	return false, "", nil
+}
+
+// 日历是否被定时任务引用
+func IsCalendarInUse(id bson.ObjectId) (bool, error) {
+	count, err := model.GetScheduleCount(bson.M{"calendar_ids": id})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"crawlab/model"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestIsTimeInCalendar(t *testing.T) {
+	Convey("Test IsTimeInCalendar", t, func() {
+		cal := model.Calendar{
+			Name:  "maintenance",
+			Dates: []string{"2020-10-01"},
+			Windows: []model.CalendarWindow{
+				{Weekdays: []int{0}, Start: "02:00", End: "04:00"},
+				{Weekdays: []int{5}, Start: "23:00", End: "01:00"},
+			},
+		}
+
+		Convey("date", func() {
+			excluded, reason := IsTimeInCalendar(cal, time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC), time.UTC)
+			So(excluded, ShouldBeTrue)
+			So(reason, ShouldContainSubstring, "2020-10-01")
+
+			// 按日历时区计算日期
+			excluded, _ = IsTimeInCalendar(cal, time.Date(2020, 9, 30, 20, 0, 0, 0, time.UTC), time.FixedZone("UTC+8", 8*3600))
+			So(excluded, ShouldBeTrue)
+		})
+		Convey("window", func() {
+			// 2020-10-04 是星期日
+			excluded, _ := IsTimeInCalendar(cal, time.Date(2020, 10, 4, 3, 0, 0, 0, time.UTC), time.UTC)
+			So(excluded, ShouldBeTrue)
+			excluded, _ = IsTimeInCalendar(cal, time.Date(2020, 10, 4, 4, 0, 0, 0, time.UTC), time.UTC)
+			So(excluded, ShouldBeFalse)
+			excluded, _ = IsTimeInCalendar(cal, time.Date(2020, 10, 5, 3, 0, 0, 0, time.UTC), time.UTC)
+			So(excluded, ShouldBeFalse)
+		})
+		Convey("window across midnight", func() {
+			// 2020-10-02 是星期五
+			excluded, _ := IsTimeInCalendar(cal, time.Date(2020, 10, 2, 23, 30, 0, 0, time.UTC), time.UTC)
+			So(excluded, ShouldBeTrue)
+			excluded, _ = IsTimeInCalendar(cal, time.Date(2020, 10, 3, 0, 30, 0, 0, time.UTC), time.UTC)
+			So(excluded, ShouldBeTrue)
+			excluded, _ = IsTimeInCalendar(cal, time.Date(2020, 10, 4, 0, 30, 0, 0, time.UTC), time.UTC)
+			So(excluded, ShouldBeFalse)
+		})
+	})
+}
+
+func TestValidateCalendar(t *testing.T) {
+	Convey("Test ValidateCalendar", t, func() {
+		So(ValidateCalendar(model.Calendar{Name: "holidays", Dates: []string{"2020-10-01"}}), ShouldBeNil)
+		So(ValidateCalendar(model.Calendar{Name: "holidays", Dates: []string{"2020-13-01"}}), ShouldNotBeNil)
+		So(ValidateCalendar(model.Calendar{Name: "holidays", Timezone: "Mars/Olympus"}), ShouldNotBeNil)
+		So(ValidateCalendar(model.Calendar{Name: "window", Windows: []model.CalendarWindow{{Start: "25:00", End: "01:00"}}}), ShouldNotBeNil)
+		So(ValidateCalendar(model.Calendar{Name: "window", Windows: []model.CalendarWindow{{Weekdays: []int{7}, Start: "01:00", End: "02:00"}}}), ShouldNotBeNil)
+		So(ValidateCalendar(model.Calendar{Name: "window", Windows: []model.CalendarWindow{{Start: "01:00", End: "01:00"}}}), ShouldNotBeNil)
+		So(ValidateCalendar(model.Calendar{}), ShouldNotBeNil)
+	})
+}
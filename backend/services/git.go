@@ -1,6 +1,7 @@
 package services
 
 import (
+	"crawlab/constants"
 	"crawlab/lib/cron"
 	"crawlab/model"
 	"crawlab/services/spider_handler"
@@ -8,6 +9,7 @@ import (
 	"fmt"
 	"github.com/apex/log"
 	"github.com/globalsign/mgo/bson"
+	"go.uber.org/atomic"
 	"gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
@@ -27,7 +29,8 @@ import (
 var GitCron *GitCronScheduler
 
 type GitCronScheduler struct {
-	cron *cron.Cron
+	cron   *cron.Cron
+	active atomic.Bool // 当前节点为调度节点时运行
 }
 
 type GitBranch struct {
@@ -59,12 +62,14 @@ func (g *GitCronScheduler) Start() error {
 	c := cron.New(cron.WithSeconds())
 
 	// 启动cron服务
+	g.active.Store(true)
 	g.cron.Start()
 
-	// 更新任务列表
+	// 更新任务列表，失败时停止cron服务
 	if err := g.Update(); err != nil {
 		log.Errorf("update scheduler error: %s", err.Error())
 		debug.PrintStack()
+		g.Stop()
 		return err
 	}
 
@@ -79,6 +84,13 @@ func (g *GitCronScheduler) Start() error {
 	return nil
 }
 
+// 停止 Git 定时任务（不再是调度节点时）
+func (g *GitCronScheduler) Stop() {
+	g.active.Store(false)
+	g.RemoveAll()
+	g.cron.Stop()
+}
+
 func (g *GitCronScheduler) RemoveAll() {
 	entries := g.cron.Entries()
 	for i := 0; i < len(entries); i++ {
@@ -87,6 +99,11 @@ func (g *GitCronScheduler) RemoveAll() {
 }
 
 func (g *GitCronScheduler) Update() error {
+	// 非调度节点通知调度节点更新
+	if !g.active.Load() {
+		return NotifySchedulerLeader(constants.MsgTypeReloadGitCron)
+	}
+
 	// 删除所有定时任务
 	g.RemoveAll()
 
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/database"
+	"crawlab/entity"
+	"crawlab/services/local_node"
+	"encoding/json"
+	"github.com/apex/log"
+	"github.com/gomodule/redigo/redis"
+	"github.com/spf13/viper"
+	"go.uber.org/atomic"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// 调度租约key，持有租约的主节点为调度节点，运行定时任务和 Git 定时同步
+const schedulerLeaseKey = "scheduler-leader"
+
+var Leader *LeaderElector
+
+// 调度节点选举
+type LeaderElector struct {
+	NodeId string        // 当前节点ID
+	Ttl    time.Duration // 租约有效期
+
+	leader   atomic.Bool
+	mu       sync.Mutex
+	resigned bool // 已退出选举
+	stopCh   chan struct{}
+
+	OnElected func() error // 成为调度节点，返回错误时放弃调度节点身份
+	OnRevoked func()       // 失去调度节点身份
+}
+
+func NewLeaderElector(nodeId string, ttl time.Duration) *LeaderElector {
+	return &LeaderElector{
+		NodeId:    nodeId,
+		Ttl:       ttl,
+		stopCh:    make(chan struct{}),
+		OnElected: func() error { return nil },
+		OnRevoked: func() {},
+	}
+}
+
+// 当前节点是否为调度节点
+func (l *LeaderElector) IsLeader() bool {
+	return l.leader.Load()
+}
+
+// 获得或续约租约，租约丢失时停止调度
+// 启动和停止调度服务（OnElected/OnRevoked）需要读写数据库，不在锁内执行
+func (l *LeaderElector) tick() {
+	if l.leader.Load() {
+		ok, err := database.RedisClient.RenewLease(schedulerLeaseKey, l.NodeId, l.Ttl)
+		if err != nil {
+			log.Errorf("renew scheduler lease error: %s", err.Error())
+		}
+		if err != nil || !ok {
+			// 无法确认租约时立即停止，避免与新的调度节点重复触发
+			if l.leader.CAS(true, false) {
+				log.Warnf("lost scheduler lease, node %s steps down", l.NodeId)
+				l.OnRevoked()
+			}
+		}
+		return
+	}
+
+	ok, err := database.RedisClient.AcquireLease(schedulerLeaseKey, l.NodeId, l.Ttl)
+	if err != nil {
+		log.Errorf("acquire scheduler lease error: %s", err.Error())
+		return
+	}
+	if !ok {
+		// 租约仍属于当前节点（例如续约时 Redis 短暂不可用）
+		ok, err = database.RedisClient.RenewLease(schedulerLeaseKey, l.NodeId, l.Ttl)
+		if err != nil || !ok {
+			return
+		}
+	}
+	log.Infof("node %s is elected as scheduler leader", l.NodeId)
+
+	// 调度服务启动成功后才成为调度节点，失败时释放租约，由其他主节点接管
+	if err := l.OnElected(); err != nil {
+		log.Errorf("start scheduler leader services error: %s", err.Error())
+		debug.PrintStack()
+		l.OnRevoked()
+		l.releaseLease()
+		return
+	}
+
+	// 启动期间租约可能已过期，或者已退出选举
+	ok, err = database.RedisClient.RenewLease(schedulerLeaseKey, l.NodeId, l.Ttl)
+	l.mu.Lock()
+	if err != nil || !ok || l.resigned {
+		l.mu.Unlock()
+		log.Warnf("lost scheduler lease while starting, node %s steps down", l.NodeId)
+		l.OnRevoked()
+		l.releaseLease()
+		return
+	}
+	l.leader.Store(true)
+	l.mu.Unlock()
+}
+
+// 释放租约（仅当前节点持有时）
+func (l *LeaderElector) releaseLease() {
+	if err := database.RedisClient.ReleaseLease(schedulerLeaseKey, l.NodeId); err != nil {
+		log.Errorf("release scheduler lease error: %s", err.Error())
+		debug.PrintStack()
+	}
+}
+
+// 每 1/3 租约有效期获得或续约一次租约
+func (l *LeaderElector) Run() {
+	ticker := time.NewTicker(l.Ttl / 3)
+	defer ticker.Stop()
+
+	l.tick()
+	for {
+		select {
+		case <-l.stopCh:
+			return
+		case <-ticker.C:
+			l.tick()
+		}
+	}
+}
+
+// 退出选举并释放租约，其他主节点可立即接管
+func (l *LeaderElector) Resign() {
+	close(l.stopCh)
+
+	l.mu.Lock()
+	l.resigned = true
+	wasLeader := l.leader.CAS(true, false)
+	l.mu.Unlock()
+
+	if !wasLeader {
+		return
+	}
+	l.OnRevoked()
+	l.releaseLease()
+}
+
+// 当前节点是否为调度节点
+func IsLeader() bool {
+	return Leader != nil && Leader.IsLeader()
+}
+
+// 获取调度节点ID，没有调度节点时返回空字符串
+func GetLeaderNodeId() (string, error) {
+	return database.RedisClient.GetLeaseHolder(schedulerLeaseKey)
+}
+
+// 退出调度节点选举（关闭前）
+func ResignLeader() {
+	if Leader == nil {
+		return
+	}
+	Leader.Resign()
+}
+
+// 获取租约有效期
+func GetLeaderLeaseTtl() time.Duration {
+	ttl := viper.GetInt("server.leaderLeaseTtl")
+	if ttl <= 0 {
+		ttl = 15
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// 成为调度节点后启动定时任务，并补跑切换期间错过的触发
+func startLeaderServices() error {
+	if Sched != nil {
+		// 启动前的触发时间均为错过的触发
+		startTs := time.Now()
+		if err := Sched.Start(); err != nil {
+			log.Errorf("start scheduler error: %s", err.Error())
+			debug.PrintStack()
+			return err
+		}
+		go CatchUpMissedSchedules(startTs)
+	}
+	if GitCron != nil {
+		if err := GitCron.Start(); err != nil {
+			log.Errorf("start git cron error: %s", err.Error())
+			debug.PrintStack()
+			return err
+		}
+	}
+	return nil
+}
+
+// 失去调度节点身份后停止定时任务
+func stopLeaderServices() {
+	if Sched != nil {
+		Sched.Stop()
+	}
+	if GitCron != nil {
+		GitCron.Stop()
+	}
+}
+
+// 通知调度节点重新加载定时任务（在非调度节点上修改定时任务后）
+func NotifySchedulerLeader(msgType string) error {
+	return database.Pub(constants.ChannelSchedulerLeader, entity.NodeMessage{
+		Type:   msgType,
+		NodeId: local_node.CurrentNode().Id.Hex(),
+	})
+}
+
+// 处理发送给调度节点的消息
+func handleSchedulerLeaderMessage(message redis.Message) error {
+	if !IsLeader() {
+		return nil
+	}
+
+	var msg entity.NodeMessage
+	if err := json.Unmarshal(message.Data, &msg); err != nil {
+		return err
+	}
+	switch msg.Type {
+	case constants.MsgTypeReloadSchedules:
+		if Sched != nil {
+			return Sched.Update()
+		}
+	case constants.MsgTypeReloadGitCron:
+		if GitCron != nil {
+			return GitCron.Update()
+		}
+	}
+	return nil
+}
+
+// 初始化调度节点选举（仅主节点），多个主节点中只有一个运行定时任务
+func InitLeaderElection() error {
+	Leader = NewLeaderElector(local_node.CurrentNode().Id.Hex(), GetLeaderLeaseTtl())
+	Leader.OnElected = startLeaderServices
+	Leader.OnRevoked = stopLeaderServices
+
+	if err := database.Sub(constants.ChannelSchedulerLeader, handleSchedulerLeaderMessage); err != nil {
+		return err
+	}
+	go Leader.Run()
+	return nil
+}
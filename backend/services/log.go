@@ -6,12 +6,14 @@ import (
 	"crawlab/entity"
 	"crawlab/lib/cron"
 	"crawlab/model"
+	"crawlab/services/log_sink"
 	"crawlab/utils"
 	"github.com/apex/log"
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	"github.com/spf13/viper"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime/debug"
@@ -118,6 +120,60 @@ func RemoveLogBySpiderId(id bson.ObjectId) error {
 	return nil
 }
 
+// 删除本节点过期的文件任务日志，保留时间与任务日志一致（任务所属用户的日志过期时间，默认1天）
+func RemoveExpiredFileSinkLogs() {
+	expires := map[bson.ObjectId]time.Duration{}
+	expireOf := func(taskId string) time.Duration {
+		t, err := model.GetTask(taskId)
+		if err == mgo.ErrNotFound {
+			// 任务已删除
+			return 0
+		}
+		if err != nil {
+			// 无法确认时保留
+			return time.Duration(math.MaxInt64)
+		}
+		if expire, ok := expires[t.UserId]; ok {
+			return expire
+		}
+		expire := 24 * time.Hour
+		if u, err := model.GetUser(t.UserId); err == nil && u.Setting.LogExpireDuration > 0 {
+			expire = time.Duration(u.Setting.LogExpireDuration) * time.Second
+		}
+		expires[t.UserId] = expire
+		return expire
+	}
+
+	removed, err := log_sink.RemoveExpiredFileLogs(log_sink.GetFileSinkConfig(), time.Now(), expireOf)
+	if err != nil {
+		log.Errorf("remove expired file logs error: %s", err.Error())
+		debug.PrintStack()
+	}
+	if len(removed) > 0 {
+		log.Infof("removed expired file logs of %d tasks", len(removed))
+	}
+}
+
+// 初始化定时删除过期的文件任务日志（使用文件存储时）
+func InitFileSinkLogCleaner() error {
+	enabled := false
+	for _, sinkType := range log_sink.GetSinkTypes() {
+		if sinkType == log_sink.TypeFile {
+			enabled = true
+		}
+	}
+	if !enabled {
+		return nil
+	}
+
+	c := cron.New(cron.WithSeconds())
+	if _, err := c.AddFunc("@hourly", RemoveExpiredFileSinkLogs); err != nil {
+		return err
+	}
+	c.Start()
+	return nil
+}
+
 // 初始化定时删除日志
 func InitDeleteLogPeriodically() error {
 	c := cron.New(cron.WithSeconds())
@@ -141,6 +197,9 @@ func InitLogIndexes() error {
 	_ = c.EnsureIndex(mgo.Index{
 		Key: []string{"task_id", "msg"},
 	})
+	_ = c.EnsureIndex(mgo.Index{
+		Key: []string{"task_id", "level"},
+	})
 	_ = c.EnsureIndex(mgo.Index{
 		Key:         []string{"expire_ts"},
 		Sparse:      true,
@@ -158,6 +217,30 @@ func InitLogIndexes() error {
 		ExpireAfter: 1 * time.Second,
 	})
 
+	// 任务资源使用采样
+	sm, cm := database.GetCol("task_metrics")
+	defer sm.Close()
+	_ = cm.EnsureIndex(mgo.Index{
+		Key: []string{"task_id", "ts"},
+	})
+	_ = cm.EnsureIndex(mgo.Index{
+		Key:         []string{"expire_ts"},
+		Sparse:      true,
+		ExpireAfter: 1 * time.Second,
+	})
+
+	// 定时任务触发记录
+	sh, ch := database.GetCol("schedule_history")
+	defer sh.Close()
+	_ = ch.EnsureIndex(mgo.Index{
+		Key: []string{"schedule_id", "-fire_ts"},
+	})
+	_ = ch.EnsureIndex(mgo.Index{
+		Key:         []string{"expire_ts"},
+		Sparse:      true,
+		ExpireAfter: 1 * time.Second,
+	})
+
 	return nil
 }
 
@@ -177,6 +260,11 @@ func InitLogService() error {
 		log.Info("periodically cleaning log is switched off")
 	}
 
+	if err := InitFileSinkLogCleaner(); err != nil {
+		log.Error("init file sink log cleaner failed")
+		return err
+	}
+
 	if model.IsMaster() {
 		if err := InitLogIndexes(); err != nil {
 			log.Errorf(err.Error())
@@ -0,0 +1,282 @@
+package log_sink
+
+import (
+	"context"
+	"crawlab/model"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/olivere/elastic/v7"
+	"github.com/spf13/viper"
+	"go.uber.org/atomic"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 队列已满且超过最长等待时间，日志被丢弃
+var ErrEsQueueFull = errors.New("elasticsearch log queue is full")
+
+// Elasticsearch 批量写入配置
+type EsConfig struct {
+	Url           string
+	Index         string
+	BatchSize     int           // 每批最多写入的日志数
+	FlushInterval time.Duration // 未满一批时的写入间隔
+	QueueSize     int           // 等待写入的日志数上限
+	MaxBlock      time.Duration // 队列已满时写入的最长等待时间，超过后丢弃
+	MaxRetry      time.Duration // 批量写入失败后的最长重试时间
+}
+
+func GetEsConfig() EsConfig {
+	config := EsConfig{
+		Url:           viper.GetString("setting.esClient"),
+		Index:         viper.GetString("setting.spiderLogIndex"),
+		BatchSize:     viper.GetInt("logSink.es.batchSize"),
+		FlushInterval: time.Duration(viper.GetInt("logSink.es.flushInterval")) * time.Second,
+		QueueSize:     viper.GetInt("logSink.es.queueSize"),
+		MaxBlock:      time.Duration(viper.GetInt("logSink.es.maxBlock")) * time.Second,
+		MaxRetry:      time.Duration(viper.GetInt("logSink.es.maxRetry")) * time.Second,
+	}
+	if config.Index == "" {
+		config.Index = "spider-log"
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 10000
+	}
+	if config.MaxBlock <= 0 {
+		config.MaxBlock = 5 * time.Second
+	}
+	if config.MaxRetry <= 0 {
+		config.MaxRetry = 30 * time.Second
+	}
+	return config
+}
+
+// Elasticsearch 批量写入，所有任务共用
+// 写入速度跟不上时队列被填满，写入方阻塞（背压），等待超过 MaxBlock 后丢弃
+type EsIndexer struct {
+	client  *elastic.Client
+	config  EsConfig
+	queue   chan esQueueEntry
+	Dropped atomic.Int64 // 丢弃的日志数
+}
+
+// 写入队列中的日志，done 不为空时为刷新标记：之前加入队列的日志写入后关闭 done
+type esQueueEntry struct {
+	item model.LogItem
+	done chan struct{}
+}
+
+// 创建失败后重新创建的间隔，避免每个任务都等待连接超时
+const esIndexerRetryInterval = 30 * time.Second
+
+var esIndexer *EsIndexer
+var esIndexerErr error
+var esIndexerErrTs time.Time
+var esIndexerLock sync.Mutex
+
+// 获取共用的 Elasticsearch 批量写入，创建失败时隔一段时间后重试
+func GetEsIndexer() (*EsIndexer, error) {
+	esIndexerLock.Lock()
+	defer esIndexerLock.Unlock()
+
+	if esIndexer != nil {
+		return esIndexer, nil
+	}
+	if esIndexerErr != nil && time.Since(esIndexerErrTs) < esIndexerRetryInterval {
+		return nil, esIndexerErr
+	}
+
+	config := GetEsConfig()
+	if config.Url == "" {
+		return nil, errors.New("setting.esClient is not set")
+	}
+	client, err := elastic.NewClient(elastic.SetURL(config.Url), elastic.SetSniff(false))
+	if err != nil {
+		esIndexerErr = err
+		esIndexerErrTs = time.Now()
+		return nil, err
+	}
+	esIndexer = NewEsIndexer(client, config)
+	esIndexerErr = nil
+	return esIndexer, nil
+}
+
+func NewEsIndexer(client *elastic.Client, config EsConfig) *EsIndexer {
+	x := &EsIndexer{
+		client: client,
+		config: config,
+		queue:  make(chan esQueueEntry, config.QueueSize),
+	}
+	go x.run()
+	return x
+}
+
+// 加入写入队列，队列已满时阻塞，超过 MaxBlock 后返回 ErrEsQueueFull
+func (x *EsIndexer) enqueue(entry esQueueEntry) error {
+	select {
+	case x.queue <- entry:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(x.config.MaxBlock)
+	defer timer.Stop()
+	select {
+	case x.queue <- entry:
+		return nil
+	case <-timer.C:
+		return ErrEsQueueFull
+	}
+}
+
+// 加入写入队列，队列已满时阻塞
+func (x *EsIndexer) Add(item model.LogItem) error {
+	if err := x.enqueue(esQueueEntry{item: item}); err != nil {
+		x.Dropped.Inc()
+		return err
+	}
+	return nil
+}
+
+// 等待之前加入队列的日志写入（包括失败重试），超过 timeout 后返回错误
+func (x *EsIndexer) Flush(timeout time.Duration) error {
+	done := make(chan struct{})
+	if err := x.enqueue(esQueueEntry{done: done}); err != nil {
+		return err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		return errors.New("flush elasticsearch logs timeout")
+	}
+}
+
+func (x *EsIndexer) run() {
+	ticker := time.NewTicker(x.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]model.LogItem, 0, x.config.BatchSize)
+	for {
+		select {
+		case entry := <-x.queue:
+			if entry.done != nil {
+				if len(batch) > 0 {
+					x.flush(batch)
+					batch = batch[:0]
+				}
+				close(entry.done)
+				continue
+			}
+			batch = append(batch, entry.item)
+			if len(batch) < x.config.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		x.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// 批量写入，失败后重试，重试期间队列继续积压
+// 单条日志被限流（429）或服务端出错（5xx）时只重试这些日志，其他原因（如字段映射错误）写入失败的日志丢弃
+func (x *EsIndexer) flush(batch []model.LogItem) {
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = x.config.MaxRetry
+
+	pending := batch
+	err := backoff.Retry(func() error {
+		bulk := x.client.Bulk().Index(x.config.Index)
+		for _, item := range pending {
+			bulk.Add(elastic.NewBulkIndexRequest().Id(item.Id.Hex()).Doc(esLogDoc(item)))
+		}
+		res, err := bulk.Do(context.Background())
+		if err != nil {
+			return err
+		}
+
+		retryIds := map[string]bool{}
+		rejected := 0
+		for _, item := range res.Failed() {
+			if isEsRetryableStatus(item.Status) {
+				retryIds[item.Id] = true
+			} else {
+				rejected++
+			}
+		}
+		if rejected > 0 {
+			x.Dropped.Add(int64(rejected))
+			log.Warnf("%d log items are rejected by elasticsearch, dropped", rejected)
+		}
+
+		var next []model.LogItem
+		for _, item := range pending {
+			if retryIds[item.Id.Hex()] {
+				next = append(next, item)
+			}
+		}
+		pending = next
+		if len(pending) > 0 {
+			return errors.New(fmt.Sprintf("%d log items failed to be indexed to elasticsearch", len(pending)))
+		}
+		return nil
+	}, b)
+	if err != nil {
+		x.Dropped.Add(int64(len(pending)))
+		log.Errorf("write logs to elasticsearch error: %s, dropped %d log items", err.Error(), len(pending))
+	}
+}
+
+// 单条写入失败后是否可以重试：限流或服务端错误
+func isEsRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// Elasticsearch 中的日志文档
+func esLogDoc(item model.LogItem) map[string]interface{} {
+	return map[string]interface{}{
+		"@timestamp": item.Ts.Format(time.RFC3339Nano),
+		"@msg":       item.Message,
+		"task_id":    item.TaskId,
+		"seq":        item.Seq,
+		"stream":     item.Stream,
+		"level":      item.Level,
+		"fields":     item.Fields,
+	}
+}
+
+// Elasticsearch 存储
+type EsSink struct {
+	indexer *EsIndexer
+}
+
+func (s *EsSink) Write(items []model.LogItem) error {
+	for i, item := range items {
+		if err := s.indexer.Add(item); err != nil {
+			// 队列已满，本批剩余的日志一并丢弃
+			s.indexer.Dropped.Add(int64(len(items) - i - 1))
+			return err
+		}
+	}
+	return nil
+}
+
+// 等待本任务的日志写入 Elasticsearch
+func (s *EsSink) Close() error {
+	return s.indexer.Flush(s.indexer.config.MaxBlock + s.indexer.config.MaxRetry + s.indexer.config.FlushInterval)
+}
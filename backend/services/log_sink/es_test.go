@@ -0,0 +1,162 @@
+package log_sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"crawlab/model"
+	"github.com/globalsign/mgo/bson"
+	"github.com/olivere/elastic/v7"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEsIndexerBackPressure(t *testing.T) {
+	Convey("Test EsIndexer back-pressure", t, func() {
+		// 不启动写入，队列满后写入方阻塞
+		x := &EsIndexer{
+			config: EsConfig{MaxBlock: 50 * time.Millisecond},
+			queue:  make(chan esQueueEntry, 2),
+		}
+		s := &EsSink{indexer: x}
+
+		Convey("full queue blocks and then drops the rest of the batch", func() {
+			start := time.Now()
+			err := s.Write([]model.LogItem{{Seq: 1}, {Seq: 2}, {Seq: 3}, {Seq: 4}})
+			So(err, ShouldEqual, ErrEsQueueFull)
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 50*time.Millisecond)
+			So(x.Dropped.Load(), ShouldEqual, 2)
+		})
+		Convey("blocked writer continues once the queue is drained", func() {
+			x.config.MaxBlock = time.Second
+			So(s.Write([]model.LogItem{{Seq: 1}, {Seq: 2}}), ShouldBeNil)
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				<-x.queue
+			}()
+			So(s.Write([]model.LogItem{{Seq: 3}}), ShouldBeNil)
+			So(x.Dropped.Load(), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestEsSinkClose(t *testing.T) {
+	Convey("Test EsSink.Close flushes queued logs", t, func() {
+		var mu sync.Mutex
+		indexed := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasSuffix(r.URL.Path, "/_bulk") {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			// 每条日志两行：操作和文档
+			lines := 0
+			scanner := bufio.NewScanner(r.Body)
+			for scanner.Scan() {
+				if scanner.Text() != "" {
+					lines++
+				}
+			}
+			mu.Lock()
+			indexed += lines / 2
+			mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+		}))
+		defer server.Close()
+
+		client, err := elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+		So(err, ShouldBeNil)
+		x := NewEsIndexer(client, EsConfig{
+			Index:         "spider-log",
+			BatchSize:     100,
+			FlushInterval: time.Hour,
+			QueueSize:     100,
+			MaxBlock:      time.Second,
+			MaxRetry:      time.Second,
+		})
+		s := &EsSink{indexer: x}
+
+		var items []model.LogItem
+		for i := 1; i <= 10; i++ {
+			items = append(items, model.LogItem{Id: bson.NewObjectId(), Seq: int64(i), Message: "hello"})
+		}
+		So(s.Write(items), ShouldBeNil)
+		So(s.Close(), ShouldBeNil)
+
+		mu.Lock()
+		defer mu.Unlock()
+		So(indexed, ShouldEqual, 10)
+	})
+}
+
+func TestEsIndexerRetryFailedItems(t *testing.T) {
+	Convey("Test EsIndexer retries items rejected with 429 and drops the others", t, func() {
+		var mu sync.Mutex
+		var requests [][]string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasSuffix(r.URL.Path, "/_bulk") {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			// 操作行中的日志ID
+			var ids []string
+			scanner := bufio.NewScanner(r.Body)
+			for i := 0; scanner.Scan(); i++ {
+				if scanner.Text() == "" || i%2 == 1 {
+					continue
+				}
+				var action map[string]map[string]interface{}
+				if err := json.Unmarshal(scanner.Bytes(), &action); err == nil {
+					ids = append(ids, fmt.Sprint(action["index"]["_id"]))
+				}
+			}
+			mu.Lock()
+			requests = append(requests, ids)
+			first := len(requests) == 1
+			mu.Unlock()
+
+			w.Header().Set("Content-Type", "application/json")
+			if !first {
+				_, _ = w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+				return
+			}
+			// 第一次写入：第一条被限流，第二条字段映射错误
+			_, _ = w.Write([]byte(fmt.Sprintf(`{"took":1,"errors":true,"items":[`+
+				`{"index":{"_id":"%s","status":429,"error":{"type":"es_rejected_execution_exception"}}},`+
+				`{"index":{"_id":"%s","status":400,"error":{"type":"mapper_parsing_exception"}}}]}`, ids[0], ids[1])))
+		}))
+		defer server.Close()
+
+		client, err := elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+		So(err, ShouldBeNil)
+		x := NewEsIndexer(client, EsConfig{
+			Index:         "spider-log",
+			BatchSize:     100,
+			FlushInterval: time.Hour,
+			QueueSize:     100,
+			MaxBlock:      time.Second,
+			MaxRetry:      5 * time.Second,
+		})
+		s := &EsSink{indexer: x}
+
+		var items []model.LogItem
+		for i := 1; i <= 5; i++ {
+			items = append(items, model.LogItem{Id: bson.NewObjectId(), Seq: int64(i), Message: "hello"})
+		}
+		So(s.Write(items), ShouldBeNil)
+		So(s.Close(), ShouldBeNil)
+
+		mu.Lock()
+		defer mu.Unlock()
+		So(len(requests), ShouldEqual, 2)
+		So(len(requests[0]), ShouldEqual, 5)
+		So(requests[1], ShouldResemble, []string{items[0].Id.Hex()})
+		So(x.Dropped.Load(), ShouldEqual, 1)
+	})
+}
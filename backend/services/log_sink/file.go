@@ -0,0 +1,205 @@
+package log_sink
+
+import (
+	"compress/gzip"
+	"crawlab/model"
+	"crawlab/utils"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 本地文件存储配置
+type FileSinkConfig struct {
+	Path       string // 日志目录，每个任务一个子目录
+	MaxSize    int64  // 单个文件最大字节数，超过后轮转并压缩
+	MaxBackups int    // 每个任务保留的压缩文件数（0 为不限制）
+}
+
+func GetFileSinkConfig() FileSinkConfig {
+	path := viper.GetString("logSink.file.path")
+	if path == "" {
+		path = filepath.Join(viper.GetString("log.path"), "tasks")
+	}
+	maxSize := viper.GetInt64("logSink.file.maxSize")
+	if maxSize <= 0 {
+		maxSize = 10
+	}
+	return FileSinkConfig{
+		Path:       path,
+		MaxSize:    maxSize * 1024 * 1024,
+		MaxBackups: viper.GetInt("logSink.file.maxBackups"),
+	}
+}
+
+// 本地文件存储，每行一条 JSON 日志，超过大小后轮转为 <n>.log.gz
+type FileSink struct {
+	config FileSinkConfig
+	dir    string
+	index  int
+	file   *os.File
+	size   int64
+}
+
+func NewFileSink(config FileSinkConfig, taskId string) (*FileSink, error) {
+	s := &FileSink{
+		config: config,
+		dir:    filepath.Join(config.Path, taskId),
+	}
+	if err := os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) filePath(index int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%d.log", index))
+}
+
+// 打开下一个文件
+func (s *FileSink) open() error {
+	s.index++
+	f, err := os.OpenFile(s.filePath(s.index), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	s.file = f
+	s.size = 0
+	return nil
+}
+
+func (s *FileSink) Write(items []model.LogItem) error {
+	for _, item := range items {
+		line, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+
+		if s.size > 0 && s.size+int64(len(line)) > s.config.MaxSize {
+			if err := s.rotate(); err != nil {
+				return err
+			}
+		}
+		n, err := s.file.Write(line)
+		s.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 压缩当前文件并打开新文件
+func (s *FileSink) rotate() error {
+	if err := s.compress(); err != nil {
+		return err
+	}
+	if err := s.removeOldBackups(); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+// 关闭并压缩当前文件
+func (s *FileSink) compress() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	src := s.file.Name()
+	if err := gzipFile(src, src+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// 删除超出保留数量的压缩文件
+func (s *FileSink) removeOldBackups() error {
+	if s.config.MaxBackups <= 0 {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.log.gz"))
+	if err != nil {
+		return err
+	}
+	if len(files) <= s.config.MaxBackups {
+		return nil
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return backupIndex(files[i]) < backupIndex(files[j])
+	})
+	for _, file := range files[:len(files)-s.config.MaxBackups] {
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	return s.compress()
+}
+
+// 删除过期的任务日志目录（目录最后修改时间超过 expireOf 返回的保留时间），返回删除的任务ID
+func RemoveExpiredFileLogs(config FileSinkConfig, now time.Time, expireOf func(taskId string) time.Duration) ([]string, error) {
+	entries, err := ioutil.ReadDir(config.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var removed []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		taskId := entry.Name()
+		if entry.ModTime().Add(expireOf(taskId)).After(now) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(config.Path, taskId)); err != nil {
+			return removed, err
+		}
+		removed = append(removed, taskId)
+	}
+	return removed, nil
+}
+
+// 获取压缩文件的序号
+func backupIndex(path string) int {
+	var index int
+	_, _ = fmt.Sscanf(strings.TrimSuffix(filepath.Base(path), ".log.gz"), "%d", &index)
+	return index
+}
+
+func gzipFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer utils.Close(in)
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer utils.Close(out)
+
+	w := gzip.NewWriter(out)
+	if _, err := io.Copy(w, in); err != nil {
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,106 @@
+package log_sink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crawlab/model"
+	"crawlab/utils"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func countGzipLines(path string) int {
+	f, err := os.Open(path)
+	So(err, ShouldBeNil)
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	So(err, ShouldBeNil)
+	scanner := bufio.NewScanner(r)
+	count := 0
+	for scanner.Scan() {
+		count++
+	}
+	return count
+}
+
+func TestFileSink(t *testing.T) {
+	Convey("Test FileSink", t, func() {
+		dir, err := ioutil.TempDir("", "crawlab-log-sink")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		var items []model.LogItem
+		for i := 1; i <= 100; i++ {
+			items = append(items, model.LogItem{TaskId: "task", Seq: int64(i), Message: fmt.Sprintf("line %d", i)})
+		}
+
+		Convey("rotates and compresses files", func() {
+			s, err := NewFileSink(FileSinkConfig{Path: dir, MaxSize: 1024}, "task")
+			So(err, ShouldBeNil)
+			So(s.Write(items), ShouldBeNil)
+			So(s.Close(), ShouldBeNil)
+
+			files, _ := filepath.Glob(filepath.Join(dir, "task", "*.log.gz"))
+			So(len(files), ShouldBeGreaterThan, 1)
+			plain, _ := filepath.Glob(filepath.Join(dir, "task", "*.log"))
+			So(len(plain), ShouldEqual, 0)
+
+			total := 0
+			for _, file := range files {
+				total += countGzipLines(file)
+			}
+			So(total, ShouldEqual, 100)
+		})
+		Convey("keeps max backups", func() {
+			s, err := NewFileSink(FileSinkConfig{Path: dir, MaxSize: 1024, MaxBackups: 2}, "task")
+			So(err, ShouldBeNil)
+			So(s.Write(items), ShouldBeNil)
+			So(s.Close(), ShouldBeNil)
+
+			files, _ := filepath.Glob(filepath.Join(dir, "task", "*.log.gz"))
+			So(len(files), ShouldBeLessThanOrEqualTo, 3)
+			_, err = os.Stat(filepath.Join(dir, "task", "1.log.gz"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
+
+func TestRemoveExpiredFileLogs(t *testing.T) {
+	Convey("Test RemoveExpiredFileLogs", t, func() {
+		dir, err := ioutil.TempDir("", "crawlab-log-sink")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		now := time.Now()
+		for _, taskId := range []string{"old", "new", "long"} {
+			So(os.MkdirAll(filepath.Join(dir, taskId), os.ModePerm), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dir, taskId, "1.log.gz"), []byte("x"), 0644), ShouldBeNil)
+		}
+		twoDaysAgo := now.Add(-48 * time.Hour)
+		So(os.Chtimes(filepath.Join(dir, "old"), twoDaysAgo, twoDaysAgo), ShouldBeNil)
+		So(os.Chtimes(filepath.Join(dir, "long"), twoDaysAgo, twoDaysAgo), ShouldBeNil)
+
+		removed, err := RemoveExpiredFileLogs(FileSinkConfig{Path: dir}, now, func(taskId string) time.Duration {
+			if taskId == "long" {
+				return 7 * 24 * time.Hour
+			}
+			return 24 * time.Hour
+		})
+		So(err, ShouldBeNil)
+		So(removed, ShouldResemble, []string{"old"})
+		So(utils.Exists(filepath.Join(dir, "old")), ShouldBeFalse)
+		So(utils.Exists(filepath.Join(dir, "new")), ShouldBeTrue)
+		So(utils.Exists(filepath.Join(dir, "long")), ShouldBeTrue)
+
+		Convey("missing directory is ignored", func() {
+			removed, err := RemoveExpiredFileLogs(FileSinkConfig{Path: filepath.Join(dir, "missing")}, now, func(string) time.Duration { return 0 })
+			So(err, ShouldBeNil)
+			So(len(removed), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,268 @@
+package log_sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crawlab/model"
+	"crawlab/utils"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 兼容存储配置（AWS S3、MinIO 等）
+type S3Config struct {
+	Endpoint  string // 如 http://localhost:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string        // 对象key前缀
+	PartSize  int           // 压缩前超过该字节数后上传为一个对象
+	MaxRetry  time.Duration // 上传失败后的最长重试时间
+}
+
+func GetS3Config() S3Config {
+	region := viper.GetString("logSink.s3.region")
+	if region == "" {
+		region = "us-east-1"
+	}
+	partSize := viper.GetInt("logSink.s3.partSize")
+	if partSize <= 0 {
+		partSize = 5
+	}
+	maxRetry := viper.GetInt("logSink.s3.maxRetry")
+	if maxRetry <= 0 {
+		maxRetry = 30
+	}
+	return S3Config{
+		Endpoint:  viper.GetString("logSink.s3.endpoint"),
+		Region:    region,
+		Bucket:    viper.GetString("logSink.s3.bucket"),
+		AccessKey: viper.GetString("logSink.s3.accessKey"),
+		SecretKey: viper.GetString("logSink.s3.secretKey"),
+		Prefix:    viper.GetString("logSink.s3.prefix"),
+		PartSize:  partSize * 1024 * 1024,
+		MaxRetry:  time.Duration(maxRetry) * time.Second,
+	}
+}
+
+// S3 兼容存储客户端，只实现上传对象（AWS Signature V4 签名，路径风格的地址）
+type S3Client struct {
+	config S3Config
+	client *http.Client
+}
+
+func NewS3Client(config S3Config) (*S3Client, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, errors.New("s3 endpoint and bucket should not be empty")
+	}
+	return &S3Client{
+		config: config,
+		client: &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// 上传对象
+func (c *S3Client) PutObject(key string, body []byte, contentType string) error {
+	u, err := url.Parse(strings.TrimSuffix(c.config.Endpoint, "/"))
+	if err != nil {
+		return err
+	}
+	u.Path = "/" + c.config.Bucket + "/" + strings.TrimPrefix(key, "/")
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	SignS3Request(req, body, c.config.AccessKey, c.config.SecretKey, c.config.Region, time.Now())
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer utils.Close(res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(res.Body)
+		return errors.New(fmt.Sprintf("put s3 object error: %s, %s", res.Status, string(msg)))
+	}
+	return nil
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// 按 S3 规则编码路径（保留 /）
+func encodeS3Path(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = strings.Replace(url.QueryEscape(segment), "+", "%20", -1)
+	}
+	return strings.Join(segments, "/")
+}
+
+// 使用 AWS Signature V4 签名请求，签名包含 Host 及所有 x-amz-*、Range、Content-Type 请求头
+func SignS3Request(req *http.Request, body []byte, accessKey string, secretKey string, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := now.UTC().Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// 参与签名的请求头
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" || name == "content-type" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// 查询参数
+	query := req.URL.Query()
+	var keys []string
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			params = append(params, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		encodeS3Path(req.URL.Path),
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+secretKey), date)
+	key = hmacSha256(key, region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature,
+	))
+}
+
+// S3 兼容存储，日志压缩后按大小分为多个对象：<prefix>/<任务ID>/<序号>.log.gz
+type S3Sink struct {
+	client *S3Client
+	config S3Config
+	taskId string
+	part   int
+	size   int
+	buf    bytes.Buffer
+	gz     *gzip.Writer
+}
+
+func NewS3Sink(config S3Config, taskId string) (*S3Sink, error) {
+	client, err := NewS3Client(config)
+	if err != nil {
+		return nil, err
+	}
+	if config.MaxRetry <= 0 {
+		config.MaxRetry = 30 * time.Second
+	}
+	s := &S3Sink{
+		client: client,
+		config: config,
+		taskId: taskId,
+	}
+	s.gz = gzip.NewWriter(&s.buf)
+	return s, nil
+}
+
+func (s *S3Sink) Write(items []model.LogItem) error {
+	for _, item := range items {
+		line, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+		if _, err := s.gz.Write(line); err != nil {
+			return err
+		}
+		s.size += len(line)
+	}
+	if s.size >= s.config.PartSize {
+		return s.upload()
+	}
+	return nil
+}
+
+// 上传当前缓存的日志
+func (s *S3Sink) upload() error {
+	if s.size == 0 {
+		return nil
+	}
+	if err := s.gz.Close(); err != nil {
+		return err
+	}
+	s.part++
+	key := fmt.Sprintf("%s/%d.log.gz", s.taskId, s.part)
+	if s.config.Prefix != "" {
+		key = strings.TrimSuffix(s.config.Prefix, "/") + "/" + key
+	}
+
+	// 上传失败后重试，超过最长重试时间后丢弃，避免内存持续增长
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = s.config.MaxRetry
+	err := backoff.Retry(func() error {
+		return s.client.PutObject(key, s.buf.Bytes(), "application/gzip")
+	}, b)
+	s.buf.Reset()
+	s.gz.Reset(&s.buf)
+	s.size = 0
+	return err
+}
+
+func (s *S3Sink) Close() error {
+	return s.upload()
+}
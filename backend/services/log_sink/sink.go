@@ -0,0 +1,127 @@
+package log_sink
+
+import (
+	"crawlab/model"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/spf13/viper"
+	"runtime/debug"
+	"strings"
+)
+
+// 日志存储类型
+const (
+	TypeMongo = "mongo"
+	TypeFile  = "file"
+	TypeS3    = "s3"
+	TypeEs    = "es"
+)
+
+// 任务日志存储，每个任务创建一个
+type Sink interface {
+	// 写入一批日志，存储繁忙时阻塞（背压）
+	Write(items []model.LogItem) error
+	// 写入剩余日志并释放资源（任务日志全部写入后调用）
+	Close() error
+}
+
+// 写入多个存储，单个存储出错不影响其他存储
+type MultiSink struct {
+	sinks []Sink
+}
+
+func (m *MultiSink) Write(items []model.LogItem) error {
+	var errs []string
+	for _, s := range m.sinks {
+		if err := s.Write(items); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (m *MultiSink) Close() error {
+	var errs []string
+	for _, s := range m.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// MongoDB 存储（logs 集合），任务日志页面和实时日志从中读取
+type MongoSink struct{}
+
+func (s *MongoSink) Write(items []model.LogItem) error {
+	return model.AddLogItems(items)
+}
+
+func (s *MongoSink) Close() error {
+	return nil
+}
+
+// 获取配置的存储类型，设置了 setting.esClient 时加入 Elasticsearch
+// 任务日志页面、错误日志和实时日志只能从 MongoDB 读取，因此总是包含 mongo
+func GetSinkTypes() []string {
+	types := []string{TypeMongo}
+	hasEs := false
+	for _, item := range strings.Split(viper.GetString("logSink.types"), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" || item == TypeMongo {
+			continue
+		}
+		if item == TypeEs {
+			hasEs = true
+		}
+		types = append(types, item)
+	}
+	if !hasEs && viper.GetString("setting.esClient") != "" {
+		types = append(types, TypeEs)
+	}
+	return types
+}
+
+// 创建任务日志存储，无法创建的存储记录错误后跳过，都无法创建时写入 MongoDB
+func NewTaskSink(taskId string) Sink {
+	m := &MultiSink{}
+	for _, sinkType := range GetSinkTypes() {
+		s, err := newSink(sinkType, taskId)
+		if err != nil {
+			log.Errorf("create log sink (%s) error: %s, task id: %s", sinkType, err.Error(), taskId)
+			debug.PrintStack()
+			continue
+		}
+		m.sinks = append(m.sinks, s)
+	}
+	if len(m.sinks) == 0 {
+		return &MongoSink{}
+	}
+	return m
+}
+
+func newSink(sinkType string, taskId string) (Sink, error) {
+	switch sinkType {
+	case TypeMongo:
+		return &MongoSink{}, nil
+	case TypeFile:
+		return NewFileSink(GetFileSinkConfig(), taskId)
+	case TypeS3:
+		return NewS3Sink(GetS3Config(), taskId)
+	case TypeEs:
+		indexer, err := GetEsIndexer()
+		if err != nil {
+			return nil, err
+		}
+		return &EsSink{indexer: indexer}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("invalid log sink type: %s", sinkType))
+	}
+}
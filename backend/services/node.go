@@ -195,6 +195,11 @@ func UpdateNodeStatusPeriodically() {
 // 每60秒更新异常节点信息
 func UpdateOfflineNodeTaskToAbnormalPeriodically() {
 	for {
+		// 多个主节点时只由调度节点处理，避免重复重新运行任务
+		if !IsLeader() {
+			time.Sleep(60 * time.Second)
+			continue
+		}
 		nodes, err := model.GetNodeList(bson.M{"status": constants.StatusOffline})
 		if err != nil {
 			log.Errorf("get nodes error: " + err.Error())
@@ -202,7 +207,7 @@ func UpdateOfflineNodeTaskToAbnormalPeriodically() {
 			continue
 		}
 		for _, n := range nodes {
-			if err := model.UpdateTaskToAbnormal(n.Id); err != nil {
+			if err := UpdateTaskToAbnormal(n.Id); err != nil {
 				log.Errorf("update task to abnormal error: " + err.Error())
 				debug.PrintStack()
 				continue
@@ -246,7 +251,7 @@ func InitNodeService() error {
 	}
 
 	// 更新在当前节点执行中的任务状态为：abnormal
-	if err := model.UpdateTaskToAbnormal(node.Current().Id); err != nil {
+	if err := UpdateTaskToAbnormal(node.Current().Id); err != nil {
 		debug.PrintStack()
 		return err
 	}
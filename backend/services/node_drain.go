@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"crawlab/constants"
+	"crawlab/database"
+	"crawlab/model"
+	"crawlab/services/local_node"
+	"crawlab/services/rpc"
+	"crawlab/utils"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo/bson"
+	"github.com/spf13/viper"
+	"runtime/debug"
+	"time"
+)
+
+// 节点排空进度
+type NodeDrainStatus struct {
+	NodeId           bson.ObjectId `json:"node_id"`
+	Draining         bool          `json:"draining"`
+	DrainStartTs     time.Time     `json:"drain_start_ts"`
+	DrainDeadline    time.Time     `json:"drain_deadline"`
+	RunningTaskCount int           `json:"running_task_count"`
+	RunningTaskIds   []string      `json:"running_task_ids"`
+	PendingTaskCount int           `json:"pending_task_count"` // 节点队列中等待的任务数（排空期间不会被执行）
+	Drained          bool          `json:"drained"`            // 节点已确认不再获取任务，且运行中的任务已全部结束
+}
+
+// 设置排空状态
+func (ex *Executor) SetDraining(draining bool) {
+	ex.draining.Store(draining)
+}
+
+// 是否排空中
+func (ex *Executor) IsDraining() bool {
+	return ex.draining.Load()
+}
+
+// 是否有worker正在执行（或刚取出）任务
+func (ex *Executor) HasBusyWorker() bool {
+	busy := false
+	LockList.Range(func(key, value interface{}) bool {
+		if value.(bool) {
+			busy = true
+			return false
+		}
+		return true
+	})
+	return busy
+}
+
+// 每5秒同步本节点的排空状态，超过排空截止时间后取消仍在运行的任务
+func (ex *Executor) watchDrain() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ex.stopCh:
+			return
+		case <-ticker.C:
+			n, err := model.GetNode(local_node.CurrentNode().Id)
+			if err != nil {
+				continue
+			}
+			if n.Draining != ex.IsDraining() {
+				log.Infof("node draining: %t", n.Draining)
+			}
+			ex.SetDraining(n.Draining)
+
+			// 上报是否已没有正在执行的任务，排空进度以此为准（运行中的任务数在任务开始前有延迟）
+			idle := n.Draining && !ex.HasBusyWorker()
+			if idle != n.DrainIdle {
+				_ = model.SetNodeDrainIdle(n.Id, idle)
+			}
+
+			if n.Draining && !n.DrainDeadline.IsZero() && time.Now().After(n.DrainDeadline) {
+				CancelLocalRunningTasks()
+			}
+		}
+	}
+}
+
+// 取消本节点上运行中的任务
+func CancelLocalRunningTasks() {
+	node := local_node.CurrentNode()
+	tasks, err := model.GetTaskList(bson.M{"node_id": node.Id, "status": constants.StatusRunning}, 0, constants.Infinite, "-create_ts")
+	if err != nil {
+		log.Errorf("get running tasks error: %s", err.Error())
+		debug.PrintStack()
+		return
+	}
+	for _, t := range tasks {
+		// 只取消本进程中执行的任务
+		if !utils.TaskExecChanMap.HasChanKey(t.Id) {
+			continue
+		}
+		log.Warnf("cancel task (id: %s) as node drain deadline exceeded", t.Id)
+		if err := rpc.CancelTaskLocal(t.Id, node.Id.Hex()); err != nil {
+			log.Errorf("cancel task error: %s, task id: %s", err.Error(), t.Id)
+			debug.PrintStack()
+		}
+	}
+}
+
+// 排空节点：节点不再获取新任务，运行中的任务结束后排空完成
+// timeout 为排空截止时间（秒），超过后取消仍在运行的任务，0 表示一直等待
+func DrainNode(id bson.ObjectId, timeout int) (NodeDrainStatus, error) {
+	if _, err := model.GetNode(id); err != nil {
+		return NodeDrainStatus{}, err
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(time.Duration(timeout) * time.Second)
+	}
+	if err := model.SetNodeDrain(id, true, deadline); err != nil {
+		return NodeDrainStatus{}, err
+	}
+	return GetNodeDrainStatus(id)
+}
+
+// 结束排空，节点恢复获取任务
+func ResumeNode(id bson.ObjectId) error {
+	if _, err := model.GetNode(id); err != nil {
+		return err
+	}
+	return model.SetNodeDrain(id, false, time.Time{})
+}
+
+// 获取节点排空进度
+func GetNodeDrainStatus(id bson.ObjectId) (NodeDrainStatus, error) {
+	n, err := model.GetNode(id)
+	if err != nil {
+		return NodeDrainStatus{}, err
+	}
+
+	tasks, err := model.GetTaskList(bson.M{"node_id": id, "status": constants.StatusRunning}, 0, constants.Infinite, "-create_ts")
+	if err != nil {
+		return NodeDrainStatus{}, err
+	}
+	taskIds := []string{}
+	for _, t := range tasks {
+		taskIds = append(taskIds, t.Id)
+	}
+
+	pendingCount := 0
+	for p := constants.TaskPriorityHighest; p <= constants.TaskPriorityLowest; p++ {
+		if count, err := database.RedisClient.LLen(GetNodeQueueName(id, p)); err == nil {
+			pendingCount += count
+		}
+	}
+
+	return NodeDrainStatus{
+		NodeId:           n.Id,
+		Draining:         n.Draining,
+		DrainStartTs:     n.DrainStartTs,
+		DrainDeadline:    n.DrainDeadline,
+		RunningTaskCount: len(taskIds),
+		RunningTaskIds:   taskIds,
+		PendingTaskCount: pendingCount,
+		Drained:          n.Draining && n.DrainIdle && len(taskIds) == 0,
+	}, nil
+}
+
+// 关闭前排空本节点：不再获取新任务，等待运行中的任务结束，超过 task.drainTimeout 秒后取消仍在运行的任务
+func DrainLocalNode() {
+	if Exec == nil {
+		return
+	}
+	Exec.SetDraining(true)
+
+	timeout := viper.GetInt("task.drainTimeout")
+	if timeout <= 0 {
+		timeout = 300
+	}
+	log.Infof("draining node before shutdown, waiting up to %d sec for running tasks", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	if err := Exec.Stop(ctx); err == nil {
+		log.Info("node drained")
+		return
+	}
+
+	// 超时，取消仍在运行的任务后再等待worker退出
+	CancelLocalRunningTasks()
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel2()
+	if err := Exec.Stop(ctx2); err != nil {
+		log.Warn("stop task executor error:" + err.Error())
+	}
+}
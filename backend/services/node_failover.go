@@ -0,0 +1,97 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo/bson"
+	"time"
+)
+
+// 节点故障时任务的处理方式
+const (
+	NodeFailoverNone    = ""        // 置为异常，按重试策略重试
+	NodeFailoverMove    = "move"    // 等待中的任务移动到公共队列
+	NodeFailoverRestart = "restart" // 运行中的任务标记为异常，并创建新任务重新运行
+)
+
+// 获取离线节点上任务的处理方式，只有开启了节点故障重启的爬虫任务才重新放入公共队列
+func GetNodeFailoverAction(t model.Task, s model.Spider) string {
+	if t.Type != constants.TaskTypeSpider || !s.RestartOnNodeFailure {
+		return NodeFailoverNone
+	}
+	switch t.Status {
+	case constants.StatusPending:
+		return NodeFailoverMove
+	case constants.StatusRunning:
+		return NodeFailoverRestart
+	default:
+		return NodeFailoverNone
+	}
+}
+
+// 生成重新运行的任务，放入公共队列，重试次数不变（节点故障不计入重试）
+func NewNodeFailoverTask(t model.Task) model.Task {
+	return model.Task{
+		SpiderId:     t.SpiderId,
+		NodeId:       bson.ObjectIdHex(constants.ObjectIdNull),
+		Cmd:          t.Cmd,
+		Param:        t.Param,
+		UserId:       t.UserId,
+		RunType:      constants.RunTypeRandom,
+		ScheduleId:   t.ScheduleId,
+		Type:         t.Type,
+		Priority:     t.Priority,
+		Timeout:      t.Timeout,
+		ParentTaskId: t.Id,
+		RetryCount:   t.RetryCount,
+		BatchId:      t.BatchId,
+		Envs:         t.Envs,
+		Timezone:     t.Timezone,
+		Trigger:      t.Trigger,
+		WebhookId:    t.WebhookId,
+
+		// 工作流
+		WorkflowId:      t.WorkflowId,
+		WorkflowRunId:   t.WorkflowRunId,
+		WorkflowNodeKey: t.WorkflowNodeKey,
+		UpstreamTaskId:  t.UpstreamTaskId,
+	}
+}
+
+// 将离线节点上的任务重新放入公共队列，由其他在线节点执行
+// 等待中的任务直接移动到公共队列；运行中的任务标记为异常，并创建新任务重新运行
+func RequeueNodeFailedTask(t model.Task) error {
+	if t.Status == constants.StatusPending {
+		// 从节点队列中移除（消息可能已丢失，仍然重新入队）
+		if _, err := RemoveTaskMessage(t); err != nil {
+			return err
+		}
+
+		t.NodeId = bson.ObjectIdHex(constants.ObjectIdNull)
+		t.RunType = constants.RunTypeRandom
+		if err := t.Save(); err != nil {
+			return err
+		}
+		if err := AssignTask(t); err != nil {
+			return err
+		}
+		log.Infof("moved pending task (id: %s) from failed node to public queue", t.Id)
+		return nil
+	}
+
+	id, err := AddTask(NewNodeFailoverTask(t))
+	if err != nil {
+		return err
+	}
+
+	t.Status = constants.StatusAbnormal
+	t.Error = fmt.Sprintf("node failure, restarted as task %s", id)
+	t.FinishTs = time.Now()
+	if err := t.Save(); err != nil {
+		return err
+	}
+	log.Infof("restarted task (id: %s) from failed node as task (id: %s)", t.Id, id)
+	return nil
+}
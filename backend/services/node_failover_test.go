@@ -0,0 +1,62 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestGetNodeFailoverAction(t *testing.T) {
+	Convey("Test GetNodeFailoverAction", t, func() {
+		optIn := model.Spider{RestartOnNodeFailure: true}
+		optOut := model.Spider{}
+
+		cases := []struct {
+			name   string
+			task   model.Task
+			spider model.Spider
+			action string
+		}{
+			{"opt-in pending task is moved", model.Task{Type: constants.TaskTypeSpider, Status: constants.StatusPending}, optIn, NodeFailoverMove},
+			{"opt-in running task is restarted", model.Task{Type: constants.TaskTypeSpider, Status: constants.StatusRunning}, optIn, NodeFailoverRestart},
+			{"opt-in retried task is restarted", model.Task{Type: constants.TaskTypeSpider, Status: constants.StatusRunning, RetryCount: 2, ParentTaskId: "parent"}, optIn, NodeFailoverRestart},
+			{"opt-in finished task is left alone", model.Task{Type: constants.TaskTypeSpider, Status: constants.StatusFinished}, optIn, NodeFailoverNone},
+			{"opt-in abnormal task is not requeued again", model.Task{Type: constants.TaskTypeSpider, Status: constants.StatusAbnormal}, optIn, NodeFailoverNone},
+			{"non-opt-in pending task becomes abnormal", model.Task{Type: constants.TaskTypeSpider, Status: constants.StatusPending}, optOut, NodeFailoverNone},
+			{"non-opt-in running task becomes abnormal", model.Task{Type: constants.TaskTypeSpider, Status: constants.StatusRunning}, optOut, NodeFailoverNone},
+			{"system task becomes abnormal", model.Task{Type: constants.TaskTypeSystem, Status: constants.StatusRunning}, optIn, NodeFailoverNone},
+		}
+		for _, c := range cases {
+			Convey(c.name, func() {
+				So(GetNodeFailoverAction(c.task, c.spider), ShouldEqual, c.action)
+			})
+		}
+	})
+}
+
+func TestNewNodeFailoverTask(t *testing.T) {
+	Convey("Test NewNodeFailoverTask", t, func() {
+		nodeId := bson.NewObjectId()
+		cases := []struct {
+			name string
+			task model.Task
+		}{
+			{"first attempt", model.Task{Id: "t1", NodeId: nodeId, RunType: constants.RunTypeSelectedNodes, Param: "-a x=1", Priority: 3}},
+			{"already retried", model.Task{Id: "t2", NodeId: nodeId, RunType: constants.RunTypeAllNodes, RetryCount: 2, ParentTaskId: "t0"}},
+		}
+		for _, c := range cases {
+			Convey(c.name, func() {
+				newTask := NewNodeFailoverTask(c.task)
+				So(newTask.NodeId.Hex(), ShouldEqual, constants.ObjectIdNull)
+				So(newTask.RunType, ShouldEqual, constants.RunTypeRandom)
+				So(newTask.ParentTaskId, ShouldEqual, c.task.Id)
+				So(newTask.RetryCount, ShouldEqual, c.task.RetryCount)
+				So(newTask.Param, ShouldEqual, c.task.Param)
+				So(newTask.Priority, ShouldEqual, c.task.Priority)
+				So(GetTaskQueueName(newTask), ShouldStartWith, "tasks:public:")
+			})
+		}
+	})
+}
@@ -5,104 +5,225 @@ import (
 	"crawlab/lib/cron"
 	"crawlab/model"
 	"errors"
+	"fmt"
 	"github.com/apex/log"
 	"github.com/globalsign/mgo/bson"
 	uuid "github.com/satori/go.uuid"
+	"go.uber.org/atomic"
+	"math/rand"
 	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
 )
 
 var Sched *Scheduler
 
 type Scheduler struct {
-	cron *cron.Cron
+	cron      *cron.Cron
+	entryIds  sync.Map    // 定时任务ID -> cron EntryID
+	lastRunTs sync.Map    // 定时任务ID -> 上一次触发时间（重新加载定时任务后仍保留）
+	caughtUp  atomic.Bool // 启动时已补跑错过的触发
+	active    atomic.Bool // 当前节点为调度节点时运行
 }
 
+// 预览触发时间的最大数量
+const MaxCronPreviewCount = 100
+
+// cron表达式解析器
+var cronParser = cron.NewParser(
+	cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+)
+
 func AddScheduleTask(s model.Schedule) func() {
 	return func() {
-		// 生成任务ID
-		id := uuid.NewV4()
+		// 记录触发时间
+		fireTs := time.Now().Truncate(time.Second)
+		if Sched != nil {
+			Sched.lastRunTs.Store(s.Id, fireTs)
+		}
+		if err := model.UpdateScheduleLastFireTs(s.Id, fireTs); err != nil {
+			log.Errorf("update schedule last fire ts error: %s", err.Error())
+			debug.PrintStack()
+		}
+
+		// 随机延迟
+		if s.Jitter > 0 {
+			time.Sleep(GetScheduleJitterDelay(s))
+
+			// 延迟期间失去调度节点身份、停用或删除了定时任务则不再创建任务
+			latest, ok := getActiveSchedule(s.Id)
+			if !ok {
+				log.Infof("skip schedule (name: %s): disabled or no longer the scheduler during jitter", s.Name)
+				return
+			}
+			s = latest
+		}
 
-		// 参数
-		var param string
+		triggered := RunScheduleTask(s, fireTs, false)
+		afterScheduleFire(s, fireTs, triggered)
+	}
+}
+
+// 获取仍由当前节点调度且处于启用状态的定时任务
+func getActiveSchedule(id bson.ObjectId) (model.Schedule, bool) {
+	if Sched != nil && !Sched.IsActive() {
+		return model.Schedule{}, false
+	}
+	s, err := model.GetSchedule(id)
+	if err != nil {
+		return s, false
+	}
+	return s, s.Enabled && s.Status != constants.ScheduleStatusStop
+}
+
+// 获取随机延迟时间，范围为 [0, Jitter) 秒
+func GetScheduleJitterDelay(s model.Schedule) time.Duration {
+	if s.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(s.Jitter) * int64(time.Second)))
+}
 
-		// 爬虫
-		spider, err := model.GetSpider(s.SpiderId)
+// 定时任务触发后更新运行次数，达到最多运行次数或不再触发时停用
+func afterScheduleFire(s model.Schedule, fireTs time.Time, triggered bool) {
+	if triggered {
+		count, err := model.IncScheduleRunCount(s.Id)
 		if err != nil {
+			log.Errorf("update schedule run count error: %s", err.Error())
+			debug.PrintStack()
 			return
 		}
+		s.RunCount = count
+	}
+	if IsScheduleExhausted(s, fireTs) && Sched != nil {
+		Sched.disableExhausted(s.Id)
+	}
+}
 
-		// scrapy 爬虫
-		if spider.IsScrapy {
-			if s.ScrapySpider == "" {
-				log.Errorf("scrapy spider is not set")
-				debug.PrintStack()
-				return
-			}
-			param = s.ScrapySpider + " -L " + s.ScrapyLogLevel + " " + s.Param
-		} else {
-			param = s.Param
+// 按定时任务创建任务，fireTs 为触发时间，catchUp 表示补跑错过的触发
+// 每次触发的结果（已触发、跳过或出错）记录到定时任务的历史中，返回是否创建了任务
+func RunScheduleTask(s model.Schedule, fireTs time.Time, catchUp bool) bool {
+	history := model.ScheduleHistory{
+		ScheduleId: s.Id,
+		FireTs:     fireTs,
+		Status:     constants.ScheduleFireTriggered,
+		TaskIds:    []string{},
+		CatchUp:    catchUp,
+	}
+	defer func() {
+		_ = history.Add()
+	}()
+	fail := func(err error) {
+		log.Errorf("run schedule (name: %s) error: %s", s.Name, err.Error())
+		debug.PrintStack()
+		history.Status = constants.ScheduleFireError
+		history.Reason = err.Error()
+	}
+
+	// 生成任务ID
+	id := uuid.NewV4()
+
+	// 参数
+	var param string
+
+	// 爬虫
+	spider, err := model.GetSpider(s.SpiderId)
+	if err != nil {
+		fail(err)
+		return false
+	}
+
+	// scrapy 爬虫
+	if spider.IsScrapy {
+		if s.ScrapySpider == "" {
+			fail(errors.New("scrapy spider is not set"))
+			return false
 		}
+		param = s.ScrapySpider + " -L " + s.ScrapyLogLevel + " " + s.Param
+	} else {
+		param = s.Param
+	}
 
-		if s.RunType == constants.RunTypeAllNodes {
-			// 所有节点
-			nodes, err := model.GetNodeList(nil)
-			if err != nil {
-				return
-			}
-			for _, node := range nodes {
-				t := model.Task{
-					Id:         id.String(),
-					SpiderId:   s.SpiderId,
-					NodeId:     node.Id,
-					Param:      param,
-					UserId:     s.UserId,
-					RunType:    constants.RunTypeAllNodes,
-					ScheduleId: s.Id,
-					Type:       constants.TaskTypeSpider,
-				}
-
-				if _, err := AddTask(t); err != nil {
-					return
-				}
-			}
-		} else if s.RunType == constants.RunTypeRandom {
-			// 随机
-			t := model.Task{
-				Id:         id.String(),
-				SpiderId:   s.SpiderId,
-				Param:      param,
-				UserId:     s.UserId,
-				RunType:    constants.RunTypeRandom,
-				ScheduleId: s.Id,
-				Type:       constants.TaskTypeSpider,
-			}
-			if _, err := AddTask(t); err != nil {
-				log.Errorf(err.Error())
-				debug.PrintStack()
-				return
+	// 排除日历
+	excluded, reason, err := CheckScheduleCalendars(s, fireTs)
+	if err != nil {
+		fail(err)
+		return false
+	}
+	if excluded {
+		log.Infof("skip schedule (name: %s): %s", s.Name, reason)
+		history.Status = constants.ScheduleFireSkipped
+		history.Reason = reason
+		return false
+	}
+
+	// 重叠策略
+	run, deferred, err := ApplyScheduleOverlapPolicy(s)
+	if err != nil {
+		fail(err)
+		return false
+	}
+	if !run {
+		history.Status = constants.ScheduleFireSkipped
+		history.Reason = fmt.Sprintf("previous run is still active (overlap policy: %s)", s.OverlapPolicy)
+		return false
+	}
+	if deferred {
+		history.Reason = "queued until previous run finishes"
+	}
+
+	// 创建任务
+	addTask := func(t model.Task) bool {
+		t.Id = id.String()
+		t.SpiderId = s.SpiderId
+		t.Param = param
+		t.UserId = s.UserId
+		t.RunType = s.RunType
+		t.ScheduleId = s.Id
+		t.Type = constants.TaskTypeSpider
+		t.Priority = s.Priority
+		t.Timeout = s.Timeout
+		t.Deferred = deferred
+		t.Timezone = s.Timezone
+		t.ScheduledTs = fireTs
+		t.CatchUp = catchUp
+
+		taskId, err := AddTask(t)
+		if err != nil {
+			fail(err)
+			return false
+		}
+		history.TaskIds = append(history.TaskIds, taskId)
+		return true
+	}
+
+	if s.RunType == constants.RunTypeAllNodes {
+		// 所有节点
+		nodes, err := model.GetNodeList(nil)
+		if err != nil {
+			fail(err)
+			return false
+		}
+		for _, node := range nodes {
+			if !addTask(model.Task{NodeId: node.Id}) {
+				return false
 			}
-		} else if s.RunType == constants.RunTypeSelectedNodes {
-			// 指定节点
-			for _, nodeId := range s.NodeIds {
-				t := model.Task{
-					Id:         id.String(),
-					SpiderId:   s.SpiderId,
-					NodeId:     nodeId,
-					Param:      param,
-					UserId:     s.UserId,
-					RunType:    constants.RunTypeSelectedNodes,
-					ScheduleId: s.Id,
-					Type:       constants.TaskTypeSpider,
-				}
-
-				if _, err := AddTask(t); err != nil {
-					return
-				}
+		}
+	} else if s.RunType == constants.RunTypeRandom {
+		// 随机
+		addTask(model.Task{})
+	} else if s.RunType == constants.RunTypeSelectedNodes {
+		// 指定节点
+		for _, nodeId := range s.NodeIds {
+			if !addTask(model.Task{NodeId: nodeId}) {
+				return false
 			}
-		} else {
-			return
 		}
+	} else {
+		fail(errors.New(fmt.Sprintf("invalid run type: %s", s.RunType)))
 	}
+	return history.Status == constants.ScheduleFireTriggered
 }
 
 func UpdateSchedules() {
@@ -116,12 +237,14 @@ func (s *Scheduler) Start() error {
 	exec := cron.New(cron.WithSeconds())
 
 	// 启动cron服务
+	s.active.Store(true)
 	s.cron.Start()
 
-	// 更新任务列表
+	// 更新任务列表，失败时停止cron服务
 	if err := s.Update(); err != nil {
 		log.Errorf("update scheduler error: %s", err.Error())
 		debug.PrintStack()
+		s.Stop()
 		return err
 	}
 
@@ -136,26 +259,43 @@ func (s *Scheduler) Start() error {
 	return nil
 }
 
-func (s *Scheduler) AddJob(job model.Schedule) error {
-	spec := job.Cron
+// 停止定时任务服务（不再是调度节点时），已触发的任务不受影响
+func (s *Scheduler) Stop() {
+	s.active.Store(false)
+	s.caughtUp.Store(false)
+	s.RemoveAll()
+	s.cron.Stop()
+}
 
-	// 添加定时任务
-	eid, err := s.cron.AddFunc(spec, AddScheduleTask(job))
+// 是否正在运行（当前节点为调度节点）
+func (s *Scheduler) IsActive() bool {
+	return s.active.Load()
+}
+
+// 获取定时任务的cron表达式，设置了时区时加上 CRON_TZ 前缀
+func GetScheduleSpec(s model.Schedule) string {
+	if s.Timezone == "" || strings.HasPrefix(s.Cron, "TZ=") || strings.HasPrefix(s.Cron, "CRON_TZ=") {
+		return s.Cron
+	}
+	return "CRON_TZ=" + s.Timezone + " " + s.Cron
+}
+
+func (s *Scheduler) AddJob(job model.Schedule) error {
+	sch, err := BuildSchedule(job)
 	if err != nil {
 		log.Errorf("add func task error: %s", err.Error())
 		debug.PrintStack()
 		return err
 	}
 
-	// 更新EntryID
-	job.EntryId = eid
+	// 添加定时任务
+	eid := s.cron.Schedule(sch, cron.FuncJob(AddScheduleTask(job)))
 
-	// 更新状态
-	job.Status = constants.ScheduleStatusRunning
-	job.Enabled = true
+	// 更新EntryID
+	s.entryIds.Store(job.Id, eid)
 
-	// 保存定时任务
-	if err := job.Save(); err != nil {
+	// 更新EntryID和状态（不覆盖运行次数和触发时间）
+	if err := model.UpdateScheduleEntry(job.Id, eid, constants.ScheduleStatusRunning, true); err != nil {
 		log.Errorf("job save error: %s", err.Error())
 		debug.PrintStack()
 		return err
@@ -164,6 +304,26 @@ func (s *Scheduler) AddJob(job model.Schedule) error {
 	return nil
 }
 
+// 停用已结束的定时任务
+func (s *Scheduler) disableExhausted(id bson.ObjectId) {
+	if value, ok := s.entryIds.Load(id); ok {
+		s.cron.Remove(value.(cron.EntryID))
+	}
+
+	schedule, err := model.GetSchedule(id)
+	if err != nil {
+		log.Errorf("get schedule error: %s", err.Error())
+		debug.PrintStack()
+		return
+	}
+	if err := model.UpdateScheduleStatus(id, constants.ScheduleStatusStop, false); err != nil {
+		log.Errorf("disable schedule error: %s", err.Error())
+		debug.PrintStack()
+		return
+	}
+	log.Infof("schedule (name: %s) is finished and disabled", schedule.Name)
+}
+
 func (s *Scheduler) RemoveAll() {
 	entries := s.cron.Entries()
 	for i := 0; i < len(entries); i++ {
@@ -171,15 +331,123 @@ func (s *Scheduler) RemoveAll() {
 	}
 }
 
+// 获取定时任务下一次和上一次的触发时间
+func (s *Scheduler) GetRunTs(id bson.ObjectId) (next time.Time, prev time.Time) {
+	if value, ok := s.lastRunTs.Load(id); ok {
+		prev = value.(time.Time)
+	}
+	value, ok := s.entryIds.Load(id)
+	if !ok {
+		return next, prev
+	}
+	entry := s.cron.Entry(value.(cron.EntryID))
+	if entry.ID == 0 {
+		return next, prev
+	}
+	if entry.Prev.After(prev) {
+		prev = entry.Prev
+	}
+	return entry.Next, prev
+}
+
+// 填充定时任务的下一次和上一次触发时间（定时任务只在调度节点运行）
+func FillScheduleRunTs(sch *model.Schedule) {
+	if Sched == nil {
+		return
+	}
+	sch.NextRunTs, sch.PrevRunTs = Sched.GetRunTs(sch.Id)
+	if sch.LastFireTs.After(sch.PrevRunTs) {
+		sch.PrevRunTs = sch.LastFireTs
+	}
+
+	// 非调度节点根据定时任务计算下一次触发时间
+	if !Sched.IsActive() && sch.Enabled && sch.Status != constants.ScheduleStatusStop {
+		if schedule, err := BuildSchedule(*sch); err == nil {
+			sch.NextRunTs = schedule.Next(time.Now())
+		}
+	}
+}
+
 // 验证cron表达式是否正确
 func ParserCron(spec string) error {
-	parser := cron.NewParser(
-		cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
-	)
+	if _, err := cronParser.Parse(spec); err != nil {
+		return err
+	}
+	return nil
+}
 
-	if _, err := parser.Parse(spec); err != nil {
+// cron表达式是否含有 H 字段（触发时间由定时任务ID决定）
+func HasCronHashField(spec string) bool {
+	fields := strings.Fields(spec)
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "TZ=") || strings.HasPrefix(fields[0], "CRON_TZ=")) {
+		fields = fields[1:]
+	}
+	for _, field := range fields {
+		for _, expr := range strings.Split(field, ",") {
+			if strings.HasPrefix(expr, "H") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 预览cron表达式从 from 开始的 n 次触发时间，hashKey 用于计算 H 字段（一般为定时任务ID）
+func PreviewCron(spec string, timezone string, hashKey string, n int, from time.Time) ([]time.Time, error) {
+	if n <= 0 {
+		n = 5
+	}
+	if n > MaxCronPreviewCount {
+		return nil, errors.New(fmt.Sprintf("count should not exceed %d", MaxCronPreviewCount))
+	}
+	if timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid timezone: %s", timezone))
+		}
+	}
+
+	sch, err := cronParser.WithHashKey(hashKey).Parse(GetScheduleSpec(model.Schedule{Cron: spec, Timezone: timezone}))
+	if err != nil {
+		return nil, err
+	}
+
+	results := []time.Time{}
+	next := from
+	for i := 0; i < n; i++ {
+		next = sch.Next(next)
+		if next.IsZero() {
+			// 无法满足的表达式
+			break
+		}
+		results = append(results, next)
+	}
+	return results, nil
+}
+
+// 校验定时任务设置
+func ValidateSchedule(s model.Schedule) error {
+	if err := ValidateScheduleOverlapPolicy(s.OverlapPolicy); err != nil {
+		return err
+	}
+	if err := ValidateScheduleMisfirePolicy(s.MisfirePolicy); err != nil {
+		return err
+	}
+	if err := ValidateScheduleType(s); err != nil {
 		return err
 	}
+	if s.MisfireMaxRuns < 0 {
+		return errors.New("misfire max runs should not be negative")
+	}
+	for _, id := range s.CalendarIds {
+		if _, err := model.GetCalendar(id); err != nil {
+			return errors.New(fmt.Sprintf("calendar not found: %s", id.Hex()))
+		}
+	}
+	if s.Timezone != "" {
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			return errors.New(fmt.Sprintf("invalid timezone: %s", s.Timezone))
+		}
+	}
 	return nil
 }
 
@@ -189,20 +457,25 @@ func (s *Scheduler) Disable(id bson.ObjectId) error {
 	if err != nil {
 		return err
 	}
-	if schedule.EntryId == 0 {
-		return errors.New("entry id not found")
-	}
 
-	// 从cron服务中删除该任务
-	s.cron.Remove(schedule.EntryId)
+	if s.active.Load() {
+		if schedule.EntryId == 0 {
+			return errors.New("entry id not found")
+		}
 
-	// 更新状态
-	schedule.Status = constants.ScheduleStatusStop
-	schedule.Enabled = false
+		// 从cron服务中删除该任务
+		s.cron.Remove(schedule.EntryId)
+	}
 
-	if err = schedule.Save(); err != nil {
+	// 更新状态
+	if err = model.UpdateScheduleStatus(id, constants.ScheduleStatusStop, false); err != nil {
 		return err
 	}
+
+	// 非调度节点通知调度节点更新
+	if !s.active.Load() {
+		return NotifySchedulerLeader(constants.MsgTypeReloadSchedules)
+	}
 	return nil
 }
 
@@ -212,6 +485,23 @@ func (s *Scheduler) Enable(id bson.ObjectId) error {
 	if err != nil {
 		return err
 	}
+	// 不补跑禁用期间的触发，重新计算运行次数
+	if !schedule.Enabled || schedule.Status == constants.ScheduleStatusStop {
+		if err := model.ResetScheduleRunState(id); err != nil {
+			return err
+		}
+		schedule.LastFireTs = time.Time{}
+		schedule.RunCount = 0
+	}
+
+	// 非调度节点只更新状态，由调度节点添加到cron服务
+	if !s.active.Load() {
+		if err := model.UpdateScheduleStatus(id, constants.ScheduleStatusRunning, true); err != nil {
+			return err
+		}
+		return NotifySchedulerLeader(constants.MsgTypeReloadSchedules)
+	}
+
 	if err := s.AddJob(schedule); err != nil {
 		return err
 	}
@@ -219,6 +509,11 @@ func (s *Scheduler) Enable(id bson.ObjectId) error {
 }
 
 func (s *Scheduler) Update() error {
+	// 非调度节点通知调度节点更新
+	if !s.active.Load() {
+		return NotifySchedulerLeader(constants.MsgTypeReloadSchedules)
+	}
+
 	// 删除所有定时任务
 	s.RemoveAll()
 
@@ -250,6 +545,14 @@ func (s *Scheduler) Update() error {
 			job.UserId = user.Id
 		}
 
+		// 已结束的定时任务不再添加，启动时先补跑错过的触发再停用
+		if IsScheduleExhausted(job, time.Now()) {
+			if s.caughtUp.Load() {
+				s.disableExhausted(job.Id)
+			}
+			continue
+		}
+
 		// 添加到定时任务
 		if err := s.AddJob(job); err != nil {
 			log.Errorf("add job error: %s, job: %s, cron: %s", err.Error(), job.Name, job.Cron)
@@ -258,17 +561,31 @@ func (s *Scheduler) Update() error {
 		}
 	}
 
+	// 工作流定时触发
+	wList, err := model.GetWorkflowList(bson.M{"enabled": true})
+	if err != nil {
+		log.Errorf("get workflow list error: %s", err.Error())
+		debug.PrintStack()
+		return err
+	}
+	for _, w := range wList {
+		if w.Cron == "" {
+			continue
+		}
+		if _, err := s.cron.AddFunc(w.Cron, RunWorkflowFunc(w)); err != nil {
+			log.Errorf("add workflow job error: %s, workflow: %s, cron: %s", err.Error(), w.Name, w.Cron)
+			debug.PrintStack()
+			return err
+		}
+	}
+
 	return nil
 }
 
+// 构造定时任务服务，当前节点成为调度节点后启动
 func InitScheduler() error {
 	Sched = &Scheduler{
 		cron: cron.New(cron.WithSeconds()),
 	}
-	if err := Sched.Start(); err != nil {
-		log.Errorf("start scheduler error: %s", err.Error())
-		debug.PrintStack()
-		return err
-	}
 	return nil
 }
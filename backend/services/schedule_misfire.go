@@ -0,0 +1,163 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo/bson"
+	"github.com/spf13/viper"
+	"runtime/debug"
+	"time"
+)
+
+// 计算错过的触发时间时最多遍历的次数，避免触发频繁的定时任务停机较久时耗时过长
+const maxMisfireScanCount = 100000
+
+// 校验错过触发的处理策略
+func ValidateScheduleMisfirePolicy(policy string) error {
+	switch policy {
+	case "", constants.ScheduleMisfireIgnore, constants.ScheduleMisfireRunOnce, constants.ScheduleMisfireRunAll:
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("invalid misfire policy: %s", policy))
+	}
+}
+
+// 获取 run_all 最多补跑次数
+func GetScheduleMisfireMaxRuns(s model.Schedule) int {
+	if s.MisfireMaxRuns > 0 {
+		return s.MisfireMaxRuns
+	}
+	maxRuns := viper.GetInt("task.misfireMaxRuns")
+	if maxRuns <= 0 {
+		maxRuns = 10
+	}
+	return maxRuns
+}
+
+// 定时任务错过的触发
+type ScheduleMisfire struct {
+	FireTimes []time.Time // 最早的若干次错过的触发时间
+	Last      time.Time   // 最后一次错过的触发时间
+	Count     int         // 错过的触发次数
+}
+
+// 计算定时任务上一次触发之后、now 之前错过的触发，最多保留 limit 个触发时间
+func GetScheduleMisfire(s model.Schedule, now time.Time, limit int) (ScheduleMisfire, error) {
+	var misfire ScheduleMisfire
+
+	// 从未触发过的定时任务不补跑，once 类型除外
+	base := s.LastFireTs
+	if base.IsZero() && s.Type == constants.ScheduleTypeOnce && !s.StartTs.IsZero() {
+		base = s.StartTs.Truncate(time.Second).Add(-time.Second)
+	}
+	if base.IsZero() {
+		return misfire, nil
+	}
+
+	sch, err := BuildSchedule(s)
+	if err != nil {
+		return misfire, err
+	}
+
+	next := sch.Next(base)
+	for !next.IsZero() && next.Before(now) && misfire.Count < maxMisfireScanCount {
+		if len(misfire.FireTimes) < limit {
+			misfire.FireTimes = append(misfire.FireTimes, next)
+		}
+		misfire.Last = next
+		misfire.Count++
+		next = sch.Next(next)
+	}
+	return misfire, nil
+}
+
+// 按错过触发的处理策略补跑定时任务，返回补跑次数
+// 补跑的任务同样受重叠策略限制
+func ApplyScheduleMisfirePolicy(s model.Schedule, now time.Time) (int, error) {
+	maxRuns := GetScheduleMisfireMaxRuns(s)
+	misfire, err := GetScheduleMisfire(s, now, maxRuns)
+	if err != nil {
+		return 0, err
+	}
+	if misfire.Count == 0 {
+		return 0, nil
+	}
+
+	// 记录触发时间，避免再次重启时重复补跑
+	if err := model.UpdateScheduleLastFireTs(s.Id, misfire.Last); err != nil {
+		return 0, err
+	}
+
+	var fireTimes []time.Time
+	switch s.MisfirePolicy {
+	case constants.ScheduleMisfireRunOnce:
+		fireTimes = []time.Time{misfire.Last}
+	case constants.ScheduleMisfireRunAll:
+		fireTimes = misfire.FireTimes
+		if misfire.Count > len(fireTimes) {
+			log.Warnf("schedule (name: %s) missed %d runs, only %d will be run", s.Name, misfire.Count, len(fireTimes))
+		}
+	default:
+		log.Infof("ignore %d missed runs of schedule (name: %s)", misfire.Count, s.Name)
+		return 0, nil
+	}
+
+	// 不超过剩余运行次数
+	if s.MaxRuns > 0 {
+		remaining := s.MaxRuns - s.RunCount
+		if remaining < 0 {
+			remaining = 0
+		}
+		if len(fireTimes) > remaining {
+			fireTimes = fireTimes[:remaining]
+		}
+	}
+
+	for _, fireTs := range fireTimes {
+		log.Infof("catch up missed run of schedule (name: %s) at %s", s.Name, fireTs.Format(time.RFC3339))
+		triggered := RunScheduleTask(s, fireTs, true)
+		if triggered {
+			if count, err := model.IncScheduleRunCount(s.Id); err == nil {
+				s.RunCount = count
+			}
+		}
+	}
+	return len(fireTimes), nil
+}
+
+// 主节点启动时补跑停机期间错过的触发，startTs 为定时任务服务的启动时间
+func CatchUpMissedSchedules(startTs time.Time) {
+	sList, err := model.GetScheduleList(bson.M{"enabled": true})
+	if err != nil {
+		log.Errorf("get schedule list error: %s", err.Error())
+		debug.PrintStack()
+		return
+	}
+
+	for _, s := range sList {
+		// 补跑期间失去调度节点身份，由新的调度节点补跑
+		if Sched != nil && !Sched.IsActive() {
+			return
+		}
+		if s.Status == constants.ScheduleStatusStop {
+			continue
+		}
+		if _, err := ApplyScheduleMisfirePolicy(s, startTs); err != nil {
+			log.Errorf("apply misfire policy error: %s, schedule: %s", err.Error(), s.Name)
+			debug.PrintStack()
+		}
+
+		// 停用已结束的定时任务
+		latest, err := model.GetSchedule(s.Id)
+		if err == nil && Sched != nil && IsScheduleExhausted(latest, startTs) {
+			Sched.disableExhausted(s.Id)
+		}
+	}
+
+	if Sched != nil {
+		Sched.caughtUp.Store(true)
+	}
+}
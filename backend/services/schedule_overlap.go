@@ -0,0 +1,134 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo/bson"
+	"runtime/debug"
+	"time"
+)
+
+// 校验重叠策略
+func ValidateScheduleOverlapPolicy(policy string) error {
+	switch policy {
+	case "", constants.ScheduleOverlapAllow, constants.ScheduleOverlapSkip, constants.ScheduleOverlapQueue, constants.ScheduleOverlapReplace:
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("invalid overlap policy: %s", policy))
+	}
+}
+
+// 定时任务未结束（等待中或运行中）的任务
+func getActiveScheduleTasks(scheduleId bson.ObjectId) ([]model.Task, error) {
+	query := bson.M{
+		"schedule_id": scheduleId,
+		"status": bson.M{
+			"$in": []string{
+				constants.StatusPending,
+				constants.StatusRunning,
+			},
+		},
+	}
+	return model.GetTaskList(query, 0, constants.Infinite, "-create_ts")
+}
+
+// 按重叠策略检查定时任务本次是否运行，以及创建的任务是否延后入队
+// 检查基于任务表，因此对整个集群生效
+func ApplyScheduleOverlapPolicy(s model.Schedule) (run bool, deferred bool, err error) {
+	if s.OverlapPolicy == "" || s.OverlapPolicy == constants.ScheduleOverlapAllow {
+		return true, false, nil
+	}
+
+	tasks, err := getActiveScheduleTasks(s.Id)
+	if err != nil {
+		return false, false, err
+	}
+	if len(tasks) == 0 {
+		return true, false, nil
+	}
+
+	switch s.OverlapPolicy {
+	case constants.ScheduleOverlapSkip:
+		log.Infof("skip schedule (name: %s) as previous run is still active", s.Name)
+		return false, false, nil
+	case constants.ScheduleOverlapQueue:
+		// 已有等待中的运行时不再重复排队
+		for _, t := range tasks {
+			if t.Deferred {
+				log.Infof("skip schedule (name: %s) as a run is already queued", s.Name)
+				return false, false, nil
+			}
+		}
+		return true, true, nil
+	case constants.ScheduleOverlapReplace:
+		for _, t := range tasks {
+			if err := cancelReplacedTask(t); err != nil {
+				log.Errorf("cancel replaced task error: %s, task id: %s", err.Error(), t.Id)
+				debug.PrintStack()
+			}
+		}
+		return true, false, nil
+	}
+	return true, false, nil
+}
+
+// 取消被新一次运行替换的任务
+func cancelReplacedTask(t model.Task) error {
+	if t.Status == constants.StatusRunning {
+		return CancelTask(t.Id)
+	}
+
+	// 等待中的任务直接从队列中移除
+	if !t.Deferred {
+		ok, err := RemoveTaskMessage(t)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// 已被执行器获取
+			return CancelTask(t.Id)
+		}
+	}
+	t.Status = constants.StatusCancelled
+	t.Error = "replaced by a new run of the schedule"
+	t.FinishTs = time.Now()
+	return t.Save()
+}
+
+// 定时任务上一次运行结束后，将延后入队的任务放入任务队列
+func ReleaseDeferredScheduleTasks(scheduleId bson.ObjectId) {
+	if !scheduleId.Valid() || scheduleId.Hex() == constants.ObjectIdNull {
+		return
+	}
+
+	tasks, err := getActiveScheduleTasks(scheduleId)
+	if err != nil {
+		log.Errorf("get schedule tasks error: %s", err.Error())
+		debug.PrintStack()
+		return
+	}
+
+	// 仍有运行中或已入队的任务
+	var deferredTasks []model.Task
+	for _, t := range tasks {
+		if !t.Deferred {
+			return
+		}
+		deferredTasks = append(deferredTasks, t)
+	}
+
+	for _, t := range deferredTasks {
+		ok, err := model.ClaimDeferredTask(t.Id)
+		if err != nil || !ok {
+			continue
+		}
+		t.Deferred = false
+		if err := AssignTask(t); err != nil {
+			log.Errorf("assign deferred task error: %s, task id: %s", err.Error(), t.Id)
+			debug.PrintStack()
+		}
+	}
+}
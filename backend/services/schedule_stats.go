@@ -0,0 +1,123 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"github.com/globalsign/mgo/bson"
+	"time"
+)
+
+// 计算定时任务状态时参考的最近任务数
+const scheduleHealthTaskCount = 10
+
+// 最近任务成功率不低于该值为正常
+const scheduleHealthySuccessRate = 0.9
+
+// 最近连续失败达到该次数为失败
+const scheduleFailingStreak = 3
+
+// 定时任务运行历史
+type ScheduleRunHistory struct {
+	Status string                  `json:"status"`
+	Health string                  `json:"health"`
+	Stats  model.ScheduleTaskStats `json:"stats"`
+	Total  int                     `json:"total"`
+	Tasks  []model.Task            `json:"tasks"`
+}
+
+// 是否为失败状态
+func isTaskFailed(status string) bool {
+	return status == constants.StatusError || status == constants.StatusAbnormal || status == constants.StatusTimeout
+}
+
+// 根据最近已结束任务的状态（按创建时间倒序）计算定时任务的运行情况，没有任务时返回空
+func ComputeScheduleHealth(statuses []string) string {
+	if len(statuses) == 0 {
+		return ""
+	}
+
+	successCount := 0
+	streak := 0
+	counting := true
+	for _, status := range statuses {
+		if isTaskFailed(status) {
+			if counting {
+				streak++
+			}
+			continue
+		}
+		counting = false
+		successCount++
+	}
+
+	if streak >= scheduleFailingStreak || successCount == 0 {
+		return constants.ScheduleStatusFailing
+	}
+	if streak == 0 && float64(successCount)/float64(len(statuses)) >= scheduleHealthySuccessRate {
+		return constants.ScheduleStatusHealthy
+	}
+	return constants.ScheduleStatusDegraded
+}
+
+// 运行中的定时任务根据最近的运行情况填充 Health，不修改 Status
+func FillScheduleHealth(s *model.Schedule) {
+	list := []model.Schedule{*s}
+	FillScheduleListHealth(list)
+	s.Health = list[0].Health
+}
+
+// 批量填充定时任务的运行情况（一次查询所有定时任务最近的任务）
+func FillScheduleListHealth(list []model.Schedule) {
+	var ids []bson.ObjectId
+	for _, s := range list {
+		if s.Enabled && s.Status == constants.ScheduleStatusRunning {
+			ids = append(ids, s.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	statuses, err := model.GetScheduleRecentTaskStatuses(ids, scheduleHealthTaskCount)
+	if err != nil {
+		return
+	}
+	for i := range list {
+		if !list[i].Enabled || list[i].Status != constants.ScheduleStatusRunning {
+			continue
+		}
+		list[i].Health = ComputeScheduleHealth(statuses[list[i].Id])
+	}
+}
+
+// 获取定时任务运行历史（分页任务列表及统计），days 大于 0 时只统计最近若干天
+func GetScheduleRunHistory(s model.Schedule, pageNum int, pageSize int, days int) (ScheduleRunHistory, error) {
+	var history ScheduleRunHistory
+
+	var since time.Time
+	query := bson.M{"schedule_id": s.Id}
+	if days > 0 {
+		since = time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+		query["create_ts"] = bson.M{"$gte": since}
+	}
+
+	tasks, err := model.GetTaskList(query, (pageNum-1)*pageSize, pageSize, "-create_ts")
+	if err != nil {
+		return history, err
+	}
+	total, err := model.GetTaskListTotal(query)
+	if err != nil {
+		return history, err
+	}
+	stats, err := model.GetScheduleTaskStats(s.Id, since)
+	if err != nil {
+		return history, err
+	}
+
+	FillScheduleHealth(&s)
+	history.Status = s.Status
+	history.Health = s.Health
+	history.Stats = stats
+	history.Total = total
+	history.Tasks = tasks
+	return history, nil
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestValidateSchedule(t *testing.T) {
+	Convey("Test ValidateSchedule", t, func() {
+		Convey("overlap policy", func() {
+			So(ValidateSchedule(model.Schedule{}), ShouldBeNil)
+			So(ValidateSchedule(model.Schedule{OverlapPolicy: constants.ScheduleOverlapQueue}), ShouldBeNil)
+			So(ValidateSchedule(model.Schedule{OverlapPolicy: "wait"}), ShouldNotBeNil)
+		})
+		Convey("timezone", func() {
+			So(ValidateSchedule(model.Schedule{Timezone: "America/New_York"}), ShouldBeNil)
+			So(ValidateSchedule(model.Schedule{Timezone: "Mars/Olympus"}), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestGetScheduleSpec(t *testing.T) {
+	Convey("Test GetScheduleSpec", t, func() {
+		So(GetScheduleSpec(model.Schedule{Cron: "0 0 9 * * *"}), ShouldEqual, "0 0 9 * * *")
+		So(GetScheduleSpec(model.Schedule{Cron: "0 0 9 * * *", Timezone: "Asia/Tokyo"}), ShouldEqual, "CRON_TZ=Asia/Tokyo 0 0 9 * * *")
+		So(GetScheduleSpec(model.Schedule{Cron: "CRON_TZ=UTC 0 0 9 * * *", Timezone: "Asia/Tokyo"}), ShouldEqual, "CRON_TZ=UTC 0 0 9 * * *")
+		So(ParserCron(GetScheduleSpec(model.Schedule{Cron: "0 0 9 * * *", Timezone: "Asia/Tokyo"})), ShouldBeNil)
+	})
+}
+
+func TestPreviewCron(t *testing.T) {
+	Convey("Test PreviewCron", t, func() {
+		from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		Convey("next fire times", func() {
+			results, err := PreviewCron("0 30 9 * * *", "UTC", "", 3, from)
+			So(err, ShouldBeNil)
+			So(len(results), ShouldEqual, 3)
+			So(results[0].Equal(time.Date(2020, 1, 1, 9, 30, 0, 0, time.UTC)), ShouldBeTrue)
+			So(results[2].Equal(time.Date(2020, 1, 3, 9, 30, 0, 0, time.UTC)), ShouldBeTrue)
+		})
+		Convey("timezone", func() {
+			results, err := PreviewCron("0 0 9 * * *", "Asia/Tokyo", "", 1, from)
+			So(err, ShouldBeNil)
+			So(results[0].Equal(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)), ShouldBeTrue)
+		})
+		Convey("default count", func() {
+			results, err := PreviewCron("@every 1h", "", "", 0, from)
+			So(err, ShouldBeNil)
+			So(len(results), ShouldEqual, 5)
+		})
+		Convey("invalid input", func() {
+			_, err := PreviewCron("0 0 9 * *", "", "", 5, from)
+			So(err, ShouldNotBeNil)
+			_, err = PreviewCron("0 0 9 * * *", "Mars/Olympus", "", 5, from)
+			So(err, ShouldNotBeNil)
+			_, err = PreviewCron("0 0 9 * * *", "", "", MaxCronPreviewCount+1, from)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestGetScheduleMisfire(t *testing.T) {
+	Convey("Test GetScheduleMisfire", t, func() {
+		last := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+		s := model.Schedule{Cron: "0 0 * * * *", Timezone: "UTC", LastFireTs: last}
+
+		Convey("missed runs", func() {
+			misfire, err := GetScheduleMisfire(s, last.Add(5*time.Hour+30*time.Minute), 3)
+			So(err, ShouldBeNil)
+			So(misfire.Count, ShouldEqual, 5)
+			So(len(misfire.FireTimes), ShouldEqual, 3)
+			So(misfire.FireTimes[0].Equal(last.Add(time.Hour)), ShouldBeTrue)
+			So(misfire.Last.Equal(last.Add(5*time.Hour)), ShouldBeTrue)
+		})
+		Convey("no missed runs", func() {
+			misfire, err := GetScheduleMisfire(s, last.Add(30*time.Minute), 3)
+			So(err, ShouldBeNil)
+			So(misfire.Count, ShouldEqual, 0)
+		})
+		Convey("never fired", func() {
+			misfire, err := GetScheduleMisfire(model.Schedule{Cron: "0 0 * * * *"}, last, 3)
+			So(err, ShouldBeNil)
+			So(misfire.Count, ShouldEqual, 0)
+		})
+		Convey("policy", func() {
+			So(ValidateScheduleMisfirePolicy(constants.ScheduleMisfireRunAll), ShouldBeNil)
+			So(ValidateScheduleMisfirePolicy("run_twice"), ShouldNotBeNil)
+			So(GetScheduleMisfireMaxRuns(model.Schedule{MisfireMaxRuns: 3}), ShouldEqual, 3)
+		})
+	})
+}
+
+func TestComputeScheduleHealth(t *testing.T) {
+	Convey("Test ComputeScheduleHealth", t, func() {
+		tasks := func(statuses ...string) []string {
+			return statuses
+		}
+		ok := constants.StatusFinished
+		fail := constants.StatusError
+
+		So(ComputeScheduleHealth(nil), ShouldEqual, "")
+		So(ComputeScheduleHealth(tasks(ok, ok, ok)), ShouldEqual, constants.ScheduleStatusHealthy)
+		So(ComputeScheduleHealth(tasks(fail, ok, ok)), ShouldEqual, constants.ScheduleStatusDegraded)
+		So(ComputeScheduleHealth(tasks(ok, fail, ok, ok)), ShouldEqual, constants.ScheduleStatusDegraded)
+		So(ComputeScheduleHealth(tasks(fail, fail, fail, ok)), ShouldEqual, constants.ScheduleStatusFailing)
+		So(ComputeScheduleHealth(tasks(constants.StatusAbnormal)), ShouldEqual, constants.ScheduleStatusFailing)
+	})
+}
+
+func TestBuildSchedule(t *testing.T) {
+	Convey("Test BuildSchedule", t, func() {
+		start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+
+		Convey("interval", func() {
+			s := model.Schedule{Type: constants.ScheduleTypeInterval, Interval: 45 * 60, StartTs: start}
+			sch, err := BuildSchedule(s)
+			So(err, ShouldBeNil)
+			So(sch.Next(start).Equal(start.Add(45*time.Minute)), ShouldBeTrue)
+			// 重新加载后触发时间不变
+			So(sch.Next(start.Add(50*time.Minute)).Equal(start.Add(90*time.Minute)), ShouldBeTrue)
+			So(sch.Next(start.Add(-time.Hour)).Equal(start), ShouldBeTrue)
+		})
+		Convey("once", func() {
+			s := model.Schedule{Type: constants.ScheduleTypeOnce, StartTs: start}
+			sch, err := BuildSchedule(s)
+			So(err, ShouldBeNil)
+			So(sch.Next(start.Add(-time.Minute)).Equal(start), ShouldBeTrue)
+			So(sch.Next(start).IsZero(), ShouldBeTrue)
+			So(IsScheduleExhausted(s, start), ShouldBeTrue)
+			So(IsScheduleExhausted(s, start.Add(-time.Minute)), ShouldBeFalse)
+
+			// 停机期间错过的一次性运行
+			misfire, err := GetScheduleMisfire(s, start.Add(time.Hour), 1)
+			So(err, ShouldBeNil)
+			So(misfire.Count, ShouldEqual, 1)
+		})
+		Convey("end time", func() {
+			s := model.Schedule{Cron: "0 0 * * * *", Timezone: "UTC", EndTs: start.Add(90 * time.Minute)}
+			sch, err := BuildSchedule(s)
+			So(err, ShouldBeNil)
+			So(sch.Next(start).Equal(start.Add(time.Hour)), ShouldBeTrue)
+			So(sch.Next(start.Add(time.Hour)).IsZero(), ShouldBeTrue)
+		})
+		Convey("max runs", func() {
+			s := model.Schedule{Cron: "0 0 * * * *", MaxRuns: 3, RunCount: 2}
+			So(IsScheduleExhausted(s, start), ShouldBeFalse)
+			s.RunCount = 3
+			So(IsScheduleExhausted(s, start), ShouldBeTrue)
+		})
+		Convey("validate", func() {
+			So(ValidateScheduleType(model.Schedule{Type: constants.ScheduleTypeInterval}), ShouldNotBeNil)
+			So(ValidateScheduleType(model.Schedule{Type: constants.ScheduleTypeOnce}), ShouldNotBeNil)
+			So(ValidateScheduleType(model.Schedule{Type: "weekly"}), ShouldNotBeNil)
+			So(ValidateScheduleType(model.Schedule{MaxRuns: -1}), ShouldNotBeNil)
+			So(ValidateScheduleType(model.Schedule{Type: constants.ScheduleTypeOnce, StartTs: start}), ShouldBeNil)
+		})
+	})
+}
+
+func TestScheduleSpread(t *testing.T) {
+	Convey("Test schedule spread", t, func() {
+		Convey("hash", func() {
+			s := model.Schedule{Id: bson.NewObjectId(), Cron: "0 H H * * *", Timezone: "UTC"}
+			sch1, err := BuildSchedule(s)
+			So(err, ShouldBeNil)
+			sch2, _ := BuildSchedule(s)
+			from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			So(sch1.Next(from).Equal(sch2.Next(from)), ShouldBeTrue)
+
+			// 与预览一致
+			results, err := PreviewCron(s.Cron, s.Timezone, s.Id.Hex(), 1, from)
+			So(err, ShouldBeNil)
+			So(results[0].Equal(sch1.Next(from)), ShouldBeTrue)
+
+			So(HasCronHashField(s.Cron), ShouldBeTrue)
+			So(HasCronHashField("CRON_TZ=UTC 0 0,H 9 * * *"), ShouldBeTrue)
+			So(HasCronHashField("0 30 9 * * *"), ShouldBeFalse)
+			So(HasCronHashField("@hourly"), ShouldBeFalse)
+		})
+		Convey("jitter", func() {
+			So(GetScheduleJitterDelay(model.Schedule{}), ShouldEqual, 0)
+			for i := 0; i < 10; i++ {
+				delay := GetScheduleJitterDelay(model.Schedule{Jitter: 30})
+				So(delay, ShouldBeGreaterThanOrEqualTo, 0)
+				So(delay, ShouldBeLessThan, 30*time.Second)
+			}
+			So(ValidateScheduleType(model.Schedule{Jitter: -1}), ShouldNotBeNil)
+			So(ValidateScheduleType(model.Schedule{Type: constants.ScheduleTypeInterval, Interval: 60, Jitter: 60}), ShouldNotBeNil)
+			So(ValidateScheduleType(model.Schedule{Type: constants.ScheduleTypeInterval, Interval: 60, Jitter: 30}), ShouldBeNil)
+			So(ValidateScheduleType(model.Schedule{Cron: "0 */5 * * * *", Jitter: 300}), ShouldNotBeNil)
+			So(ValidateScheduleType(model.Schedule{Cron: "0 */5 * * * *", Jitter: 120}), ShouldBeNil)
+			So(ValidateScheduleType(model.Schedule{Type: constants.ScheduleTypeOnce, StartTs: time.Now(), Jitter: 3600}), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/lib/cron"
+	"crawlab/model"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 从起始时间开始每隔固定时间触发，重新加载定时任务后触发时间不变
+type intervalSchedule struct {
+	Start time.Time
+	Delay time.Duration
+}
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	if t.Before(s.Start) {
+		return s.Start
+	}
+	n := t.Sub(s.Start)/s.Delay + 1
+	return s.Start.Add(n * s.Delay)
+}
+
+// 在指定时间触发一次
+type onceSchedule struct {
+	At time.Time
+}
+
+func (s onceSchedule) Next(t time.Time) time.Time {
+	if s.At.After(t) {
+		return s.At
+	}
+	return time.Time{}
+}
+
+// 结束时间之后不再触发
+type boundedSchedule struct {
+	cron.Schedule
+	End time.Time
+}
+
+func (s boundedSchedule) Next(t time.Time) time.Time {
+	next := s.Schedule.Next(t)
+	if next.IsZero() || next.After(s.End) {
+		return time.Time{}
+	}
+	return next
+}
+
+// 是否为 cron 类型的定时任务
+func IsCronSchedule(s model.Schedule) bool {
+	return s.Type == "" || s.Type == constants.ScheduleTypeCron
+}
+
+// 校验定时任务类型及运行次数限制
+func ValidateScheduleType(s model.Schedule) error {
+	switch s.Type {
+	case "", constants.ScheduleTypeCron:
+	case constants.ScheduleTypeInterval:
+		if s.Interval <= 0 {
+			return errors.New("interval should be greater than 0")
+		}
+	case constants.ScheduleTypeOnce:
+		if s.StartTs.IsZero() {
+			return errors.New("start time is required")
+		}
+	default:
+		return errors.New(fmt.Sprintf("invalid schedule type: %s", s.Type))
+	}
+	if s.MaxRuns < 0 {
+		return errors.New("max runs should not be negative")
+	}
+	if s.Jitter < 0 {
+		return errors.New("jitter should not be negative")
+	}
+
+	// 随机延迟不能超过触发间隔，否则延迟创建的任务会晚于下一次触发
+	if s.Jitter > 0 {
+		if interval := GetScheduleMinInterval(s, time.Now()); interval > 0 && time.Duration(s.Jitter)*time.Second >= interval {
+			return errors.New(fmt.Sprintf("jitter should be less than the schedule interval (%s)", interval))
+		}
+	}
+	return nil
+}
+
+// 定时任务最近几次触发之间的最短间隔，无法计算（如 once 类型或表达式错误）时返回 0
+func GetScheduleMinInterval(s model.Schedule, from time.Time) time.Duration {
+	switch s.Type {
+	case constants.ScheduleTypeInterval:
+		return time.Duration(s.Interval) * time.Second
+	case constants.ScheduleTypeOnce:
+		return 0
+	}
+
+	sch, err := cronParser.WithHashKey(s.Id.Hex()).Parse(GetScheduleSpec(s))
+	if err != nil {
+		return 0
+	}
+	var interval time.Duration
+	prev := sch.Next(from)
+	for i := 0; i < 10 && !prev.IsZero(); i++ {
+		next := sch.Next(prev)
+		if next.IsZero() {
+			break
+		}
+		if d := next.Sub(prev); interval == 0 || d < interval {
+			interval = d
+		}
+		prev = next
+	}
+	return interval
+}
+
+// 根据定时任务类型生成触发计划
+func BuildSchedule(s model.Schedule) (cron.Schedule, error) {
+	var sch cron.Schedule
+	switch s.Type {
+	case constants.ScheduleTypeInterval:
+		if s.Interval <= 0 {
+			return nil, errors.New("interval should be greater than 0")
+		}
+		sch = intervalSchedule{
+			Start: s.StartTs.Truncate(time.Second),
+			Delay: cron.Every(time.Duration(s.Interval) * time.Second).Delay,
+		}
+	case constants.ScheduleTypeOnce:
+		sch = onceSchedule{At: s.StartTs.Truncate(time.Second)}
+	default:
+		// H 字段按定时任务ID分散触发时间
+		cronSch, err := cronParser.WithHashKey(s.Id.Hex()).Parse(GetScheduleSpec(s))
+		if err != nil {
+			return nil, err
+		}
+		sch = cronSch
+	}
+
+	if !s.EndTs.IsZero() {
+		sch = boundedSchedule{Schedule: sch, End: s.EndTs}
+	}
+	return sch, nil
+}
+
+// 定时任务是否已结束（达到最多运行次数，或 now 之后不再触发）
+func IsScheduleExhausted(s model.Schedule, now time.Time) bool {
+	if s.MaxRuns > 0 && s.RunCount >= s.MaxRuns {
+		return true
+	}
+	sch, err := BuildSchedule(s)
+	if err != nil {
+		return false
+	}
+	return sch.Next(now).IsZero()
+}
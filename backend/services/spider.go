@@ -25,6 +25,16 @@ import (
 	"time"
 )
 
+// 校验爬虫设置
+func ValidateSpider(s model.Spider) error {
+	if s.Timezone != "" {
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			return errors.New(fmt.Sprintf("invalid timezone: %s", s.Timezone))
+		}
+	}
+	return nil
+}
+
 type SpiderFileData struct {
 	FileName string
 	File     []byte
@@ -606,16 +616,11 @@ func InitSpiderService() error {
 	}
 
 	if model.IsMaster() {
-		// 构造 Git 定时任务
+		// 构造 Git 定时任务（由调度节点启动）
 		GitCron = &GitCronScheduler{
 			cron: cron.New(cron.WithSeconds()),
 		}
 
-		// 启动 Git 定时任务
-		if err := GitCron.Start(); err != nil {
-			return err
-		}
-
 		// 清理UserId
 		InitSpiderCleanUserIds()
 	}
@@ -0,0 +1,15 @@
+package services
+
+import (
+	"crawlab/model"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestValidateSpider(t *testing.T) {
+	Convey("Test ValidateSpider", t, func() {
+		So(ValidateSpider(model.Spider{}), ShouldBeNil)
+		So(ValidateSpider(model.Spider{Timezone: "Asia/Shanghai"}), ShouldBeNil)
+		So(ValidateSpider(model.Spider{Timezone: "Mars/Olympus"}), ShouldNotBeNil)
+	})
+}
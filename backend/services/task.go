@@ -2,11 +2,13 @@ package services
 
 import (
 	"bufio"
+	"context"
 	"crawlab/constants"
 	"crawlab/database"
 	"crawlab/lib/cron"
 	"crawlab/model"
 	"crawlab/services/local_node"
+	"crawlab/services/log_sink"
 	"crawlab/services/notification"
 	"crawlab/services/rpc"
 	"crawlab/services/spider_handler"
@@ -17,9 +19,11 @@ import (
 	"github.com/apex/log"
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
+	"github.com/gomodule/redigo/redis"
 	"github.com/imroc/req"
 	"github.com/satori/go.uuid"
 	"github.com/spf13/viper"
+	"go.uber.org/atomic"
 	"net/http"
 	"os"
 	"os/exec"
@@ -39,6 +43,15 @@ var Exec *Executor
 //Added by cloud: 2019/09/04,solve data race
 var LockList sync.Map
 
+// 本节点正在执行的任务数（用于节点并发限制）
+var nodeTaskCount atomic.Int64
+
+// 爬虫并发限制检查锁，每个爬虫一个
+var spiderSlotLocks sync.Map
+
+// 超出并发限制而重新入队的次数，用于计算等待时间
+var taskSlotAttempts sync.Map
+
 // 任务消息
 type TaskMessage struct {
 	Id  string
@@ -54,63 +67,243 @@ func (m *TaskMessage) ToString() (string, error) {
 	return utils.BytesToString(data), err
 }
 
-// 任务执行器
+// 任务执行器，固定数量的worker阻塞地从任务队列中获取任务，每个worker同时只执行一个任务
 type Executor struct {
-	Cron *cron.Cron
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+	draining atomic.Bool // 排空中，不再获取新任务
 }
 
+// 阻塞获取任务的超时时间（秒），也是停止执行器时等待空闲worker退出的最长时间
+const taskPopTimeout = 5
+
 // 启动任务执行器
 func (ex *Executor) Start() error {
-	// 启动cron服务
-	ex.Cron.Start()
+	workers := viper.GetInt("task.workers")
+	if workers <= 0 {
+		return errors.New("task.workers should be greater than 0")
+	}
 
-	// 加入执行器到定时任务
-	spec := "0/1 * * * * *" // 每秒执行一次
-	for i := 0; i < viper.GetInt("task.workers"); i++ {
+	for i := 0; i < workers; i++ {
 		// WorkerID
 		id := i
 
 		// 初始化任务锁
 		LockList.Store(id, false)
 
-		// 加入定时任务
-		_, err := ex.Cron.AddFunc(spec, GetExecuteTaskFunc(id))
+		// 启动worker
+		ex.wg.Add(1)
+		go ex.runWorker(id)
+	}
+
+	// 同步本节点的排空状态
+	go ex.watchDrain()
+
+	return nil
+}
+
+// 停止任务执行器：不再获取新任务，等待worker执行完当前任务后退出
+func (ex *Executor) Stop(ctx context.Context) error {
+	select {
+	case <-ex.stopCh:
+	default:
+		close(ex.stopCh)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ex.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 执行器是否已停止
+func (ex *Executor) IsStopped() bool {
+	select {
+	case <-ex.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// worker循环：阻塞获取任务并执行
+func (ex *Executor) runWorker(id int) {
+	defer ex.wg.Done()
+
+	for !ex.IsStopped() {
+		// 排空中，不再获取新任务
+		if ex.IsDraining() {
+			time.Sleep(time.Second)
+			continue
+		}
+
+		queue, msg, err := PopTaskMessageBlocking(local_node.CurrentNode().Id, taskPopTimeout)
 		if err != nil {
-			return err
+			if err != redis.ErrNil {
+				log.Errorf(GetWorkerPrefix(id) + "pop task message error: " + err.Error())
+				// 避免Redis不可用时空转
+				time.Sleep(time.Second)
+			}
+			continue
 		}
+
+		// 先标记为忙碌再检查排空状态，与 watchDrain 配合保证排空完成后不会再开始新任务
+		LockList.Store(id, true)
+		if ex.IsDraining() || ex.IsStopped() {
+			// 阻塞获取期间开始排空，放回队列头部
+			if err := database.RedisClient.PushFront(queue, msg); err != nil {
+				log.Errorf(GetWorkerPrefix(id) + "push back task message error: " + err.Error())
+				debug.PrintStack()
+			}
+			LockList.Store(id, false)
+			continue
+		}
+
+		// 已取出的任务总是执行完，避免丢失
+		ExecuteTask(id, msg)
 	}
 
-	return nil
+	log.Debugf(GetWorkerPrefix(id) + "stopped")
 }
 
-// 派发任务
-func AssignTask(task model.Task) error {
-	// 生成任务信息
+// 规范化任务优先级，超出范围的取默认值
+func GetTaskPriority(priority int) int {
+	if priority < constants.TaskPriorityHighest || priority > constants.TaskPriorityLowest {
+		return constants.TaskPriorityDefault
+	}
+	return priority
+}
+
+// 公共队列名称
+func GetPublicQueueName(priority int) string {
+	return "tasks:public:" + strconv.Itoa(GetTaskPriority(priority))
+}
+
+// 节点队列名称
+func GetNodeQueueName(nodeId bson.ObjectId, priority int) string {
+	return "tasks:node:" + nodeId.Hex() + ":" + strconv.Itoa(GetTaskPriority(priority))
+}
+
+// 任务所在队列名称
+func GetTaskQueueName(task model.Task) string {
+	if utils.IsObjectIdNull(task.NodeId) {
+		return GetPublicQueueName(task.Priority)
+	}
+	return GetNodeQueueName(task.NodeId, task.Priority)
+}
+
+// 生成任务消息
+func GetTaskMessageString(task model.Task) (string, error) {
 	msg := TaskMessage{
 		Id: task.Id,
 	}
+	return msg.ToString()
+}
 
-	// 序列化
-	msgStr, err := msg.ToString()
+// 派发任务
+func AssignTask(task model.Task) error {
+	// 生成任务信息
+	msgStr, err := GetTaskMessageString(task)
 	if err != nil {
 		return err
 	}
 
-	// 队列名称
-	var queue string
-	if utils.IsObjectIdNull(task.NodeId) {
-		queue = "tasks:public"
-	} else {
-		queue = "tasks:node:" + task.NodeId.Hex()
-	}
-
 	// 任务入队
-	if err := database.RedisClient.RPush(queue, msgStr); err != nil {
+	if err := database.RedisClient.RPush(GetTaskQueueName(task), msgStr); err != nil {
 		return err
 	}
 	return nil
 }
 
+// 旧版本（无优先级）的队列名称
+const legacyPublicQueueName = "tasks:public"
+
+func getLegacyNodeQueueName(nodeId bson.ObjectId) string {
+	return "tasks:node:" + nodeId.Hex()
+}
+
+// 将旧版本队列中的任务消息移入默认优先级队列，保持原有顺序并排在已有消息之前
+func MigrateLegacyTaskQueues(nodeId bson.ObjectId) error {
+	queues := map[string]string{
+		legacyPublicQueueName:          GetPublicQueueName(constants.TaskPriorityDefault),
+		getLegacyNodeQueueName(nodeId): GetNodeQueueName(nodeId, constants.TaskPriorityDefault),
+	}
+	for src, dst := range queues {
+		count := 0
+		for {
+			if _, err := database.RedisClient.RPopLPush(src, dst); err != nil {
+				if err == redis.ErrNil {
+					break
+				}
+				return err
+			}
+			count++
+		}
+		if count > 0 {
+			log.Infof("migrated %d task messages from %s to %s", count, src, dst)
+		}
+	}
+	return nil
+}
+
+// 将任务放回所在队列的头部，下次优先获取
+func AssignTaskFront(task model.Task) error {
+	msgStr, err := GetTaskMessageString(task)
+	if err != nil {
+		return err
+	}
+	return database.RedisClient.PushFront(GetTaskQueueName(task), msgStr)
+}
+
+// 从任务所在队列中移除任务消息，返回是否移除成功（未被执行器获取）
+func RemoveTaskMessage(task model.Task) (bool, error) {
+	msgStr, err := GetTaskMessageString(task)
+	if err != nil {
+		return false, err
+	}
+	n, err := database.RedisClient.LRem(GetTaskQueueName(task), 1, msgStr)
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// 节点获取任务的队列，按优先级从高到低，同一优先级先节点队列、后公共队列
+func GetTaskQueueNames(nodeId bson.ObjectId) []string {
+	var queues []string
+	for p := constants.TaskPriorityHighest; p <= constants.TaskPriorityLowest; p++ {
+		queues = append(queues, GetNodeQueueName(nodeId, p), GetPublicQueueName(p))
+	}
+	return queues
+}
+
+// 阻塞地按队列顺序获取任务消息，返回所在队列和消息，超时返回 redis.ErrNil
+func PopTaskMessageBlocking(nodeId bson.ObjectId, timeout int) (string, string, error) {
+	return database.RedisClient.BLPop(GetTaskQueueNames(nodeId), timeout)
+}
+
+// 获取任务的时区：任务（定时任务）的设置优先于爬虫，未设置时使用 task.timezone 配置
+func GetTaskTimezone(t model.Task, s model.Spider) string {
+	if t.Timezone != "" {
+		return t.Timezone
+	}
+	if s.Timezone != "" {
+		return s.Timezone
+	}
+	if !viper.IsSet("task.timezone") {
+		return "Asia/Shanghai"
+	}
+	return viper.GetString("task.timezone")
+}
+
 // 设置环境变量
 func SetEnv(cmd *exec.Cmd, envs []model.Env, task model.Task, spider model.Spider) *exec.Cmd {
 	// 默认把Node.js的全局node_modules加入环境变量
@@ -143,7 +336,12 @@ func SetEnv(cmd *exec.Cmd, envs []model.Env, task model.Task, spider model.Spide
 	}
 	cmd.Env = append(cmd.Env, "PYTHONUNBUFFERED=0")
 	cmd.Env = append(cmd.Env, "PYTHONIOENCODING=utf-8")
-	cmd.Env = append(cmd.Env, "TZ=Asia/Shanghai")
+	if tz := GetTaskTimezone(task, spider); tz != "" {
+		cmd.Env = append(cmd.Env, "TZ="+tz)
+	}
+	if task.UpstreamTaskId != "" {
+		cmd.Env = append(cmd.Env, "CRAWLAB_UPSTREAM_TASK_ID="+task.UpstreamTaskId)
+	}
 	cmd.Env = append(cmd.Env, "CRAWLAB_DEDUP_FIELD="+spider.DedupField)
 	cmd.Env = append(cmd.Env, "CRAWLAB_DEDUP_METHOD="+spider.DedupMethod)
 	if spider.IsDedup {
@@ -156,6 +354,9 @@ func SetEnv(cmd *exec.Cmd, envs []model.Env, task model.Task, spider model.Spide
 	for _, env := range envs {
 		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
 	}
+	for _, env := range task.Envs {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
 
 	// 全局环境变量
 	variables := model.GetVariableList()
@@ -167,9 +368,6 @@ func SetEnv(cmd *exec.Cmd, envs []model.Env, task model.Task, spider model.Spide
 
 func SetLogConfig(wg *sync.WaitGroup, cmd *exec.Cmd, t model.Task, u model.User) error {
 
-	esChan := make(chan string, 1)
-	esClientStr := viper.GetString("setting.esClient")
-	spiderLogIndex := viper.GetString("setting.spiderLogIndex")
 	// get stdout reader
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -190,21 +388,40 @@ func SetLogConfig(wg *sync.WaitGroup, cmd *exec.Cmd, t model.Task, u model.User)
 
 	var seq int64
 	var logs []model.LogItem
+	var logsLock sync.Mutex
 	isStdoutFinished := false
 	isStderrFinished := false
 
+	// 日志存储
+	sink := log_sink.NewTaskSink(t.Id)
+
+	// 实时日志推送
+	publisher := NewTaskLogPublisher(t.Id)
+
 	// periodically (5 sec) insert log items
 	wg.Add(3)
 	go func() {
 		defer wg.Done()
 		for {
-			_ = model.AddLogItems(logs)
+			finished := isStdoutFinished && isStderrFinished
+			logsLock.Lock()
+			items := logs
 			logs = []model.LogItem{}
-			if isStdoutFinished && isStderrFinished {
+			logsLock.Unlock()
+			if err := sink.Write(items); err != nil {
+				log.Errorf("write task log error: %s, task id: %s", err.Error(), t.Id)
+			}
+			if finished {
 				break
 			}
 			time.Sleep(5 * time.Second)
 		}
+		if err := sink.Close(); err != nil {
+			log.Errorf("close task log sink error: %s, task id: %s", err.Error(), t.Id)
+		}
+
+		// 日志已全部写入数据库，通知订阅者结束
+		publisher.Close()
 	}()
 
 	// expire duration (in seconds)
@@ -214,9 +431,32 @@ func SetLogConfig(wg *sync.WaitGroup, cmd *exec.Cmd, t model.Task, u model.User)
 		expireDuration = 3600 * 24
 	}
 
+	// 加入日志，seq 与推送顺序一致
+	addLog := func(line string, stream string, parser *LogLineParser) {
+		level, fields := parser.Parse(line)
+
+		logsLock.Lock()
+		defer logsLock.Unlock()
+		seq++
+		l := model.LogItem{
+			Id:       bson.NewObjectId(),
+			Seq:      seq,
+			Message:  line,
+			TaskId:   t.Id,
+			Stream:   stream,
+			Level:    level,
+			Fields:   fields,
+			Ts:       time.Now(),
+			ExpireTs: time.Now().Add(time.Duration(expireDuration) * time.Second),
+		}
+		logs = append(logs, l)
+		publisher.Publish(l)
+	}
+
 	// read stdout
 	go func() {
 		defer wg.Done()
+		parser := &LogLineParser{}
 		for {
 			line, err := readerStdout.ReadString('\n')
 			if err != nil {
@@ -224,28 +464,14 @@ func SetLogConfig(wg *sync.WaitGroup, cmd *exec.Cmd, t model.Task, u model.User)
 				break
 			}
 			line = strings.Replace(line, "\n", "", -1)
-			seq++
-			l := model.LogItem{
-				Id:       bson.NewObjectId(),
-				Seq:      seq,
-				Message:  line,
-				TaskId:   t.Id,
-				Ts:       time.Now(),
-				ExpireTs: time.Now().Add(time.Duration(expireDuration) * time.Second),
-			}
-
-			if esClientStr != "" {
-				esChan <- l.Message
-				go database.WriteMsgToES(time.Now(), esChan, spiderLogIndex)
-			}
-
-			logs = append(logs, l)
+			addLog(line, constants.LogStreamStdout, parser)
 		}
 	}()
 
 	// read stderr
 	go func() {
 		defer wg.Done()
+		parser := &LogLineParser{}
 		for {
 			line, err := readerStderr.ReadString('\n')
 			if err != nil {
@@ -253,21 +479,7 @@ func SetLogConfig(wg *sync.WaitGroup, cmd *exec.Cmd, t model.Task, u model.User)
 				break
 			}
 			line = strings.Replace(line, "\n", "", -1)
-			seq++
-			l := model.LogItem{
-				Id:       bson.NewObjectId(),
-				Seq:      seq,
-				Message:  line,
-				TaskId:   t.Id,
-				Ts:       time.Now(),
-				ExpireTs: time.Now().Add(time.Duration(expireDuration) * time.Second),
-			}
-
-			if esClientStr != "" {
-				esChan <- l.Message
-				go database.WriteMsgToES(time.Now(), esChan, spiderLogIndex)
-			}
-			logs = append(logs, l)
+			addLog(line, constants.LogStreamStderr, parser)
 		}
 	}()
 
@@ -280,7 +492,24 @@ func FinishOrCancelTask(ch chan string, cmd *exec.Cmd, s model.Spider, t model.T
 	signal := <-ch
 	log.Infof("process received signal: %s", signal)
 
-	if signal == constants.TaskCancel && cmd.Process != nil {
+	// 获取最新的任务数据，避免覆盖运行期间更新的字段
+	if latest, err := model.GetTask(t.Id); err == nil {
+		t = latest
+	}
+
+	if signal == constants.TaskTimeout && cmd.Process != nil {
+		// 超时，先保存状态，避免进程退出后被覆盖
+		t.Error = fmt.Sprintf("task timed out after %d sec", GetTaskTimeout(t, s))
+		t.Status = constants.StatusTimeout
+		t.FinishTs = time.Now()
+		_ = t.Save()
+
+		// 先发送SIGTERM，等待宽限期后仍未退出则发送SIGKILL
+		if err := TerminateTaskProcess(cmd); err != nil {
+			log.Errorf("process terminate error: %s", err.Error())
+			debug.PrintStack()
+		}
+	} else if signal == constants.TaskCancel && cmd.Process != nil {
 		var err error
 		// 兼容windows
 		if runtime.GOOS == constants.Windows {
@@ -310,6 +539,56 @@ func FinishOrCancelTask(ch chan string, cmd *exec.Cmd, s model.Spider, t model.T
 	go FinishUpTask(s, t)
 }
 
+// 获取任务超时时间（秒），任务上的设置优先于爬虫
+func GetTaskTimeout(t model.Task, s model.Spider) int {
+	if t.Timeout > 0 {
+		return t.Timeout
+	}
+	return s.Timeout
+}
+
+// 优雅终止任务进程组：先发送SIGTERM，宽限期后仍存活则发送SIGKILL
+func TerminateTaskProcess(cmd *exec.Cmd) error {
+	// 兼容windows
+	if runtime.GOOS == constants.Windows {
+		return cmd.Process.Kill()
+	}
+
+	pgid := -cmd.Process.Pid
+	if err := syscall.Kill(pgid, syscall.SIGTERM); err != nil {
+		return err
+	}
+
+	gracePeriod := viper.GetInt("task.timeoutGracePeriod")
+	if gracePeriod <= 0 {
+		gracePeriod = 10
+	}
+	deadline := time.Now().Add(time.Duration(gracePeriod) * time.Second)
+	for time.Now().Before(deadline) {
+		// 进程组已全部退出
+		if err := syscall.Kill(pgid, 0); err == syscall.ESRCH {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	log.Warnf("process group %d did not exit within %d sec, sending SIGKILL", cmd.Process.Pid, gracePeriod)
+	if err := syscall.Kill(pgid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	return nil
+}
+
+// 资源限制无法生效，任务置为错误状态
+func SaveTaskLimitError(t model.Task, err error) error {
+	log.Errorf("resource limits cannot be applied to task (id: %s): %s", t.Id, err.Error())
+	t.Error = "resource limits cannot be applied: " + err.Error()
+	t.Status = constants.StatusError
+	t.FinishTs = time.Now()
+	_ = t.Save()
+	return errors.New(t.Error)
+}
+
 func StartTaskProcess(cmd *exec.Cmd, t model.Task) error {
 	if err := cmd.Start(); err != nil {
 		log.Errorf("start spider error:{}", err.Error())
@@ -333,8 +612,8 @@ func WaitTaskProcess(cmd *exec.Cmd, t model.Task, s model.Spider) error {
 			exitCode := exitError.ExitCode()
 			log.Errorf("exit error, exit code: %d", exitCode)
 
-			// 非kill 的错误类型
-			if exitCode != -1 {
+			// 非kill 的错误类型，且未被超时或取消终止
+			if exitCode != -1 && !IsTaskTerminated(t.Id) {
 				// 非手动kill保存为错误状态
 				t.Error = err.Error()
 				t.FinishTs = time.Now()
@@ -351,6 +630,15 @@ func WaitTaskProcess(cmd *exec.Cmd, t model.Task, s model.Spider) error {
 	return nil
 }
 
+// 任务是否已被超时或取消终止
+func IsTaskTerminated(id string) bool {
+	t, err := model.GetTask(id)
+	if err != nil {
+		return false
+	}
+	return t.Status == constants.StatusTimeout || t.Status == constants.StatusCancelled
+}
+
 // 执行shell命令
 func ExecuteShellCmd(cmdStr string, cwd string, t model.Task, s model.Spider, u model.User) (err error) {
 	log.Infof("cwd: %s", cwd)
@@ -363,12 +651,35 @@ func ExecuteShellCmd(cmdStr string, cwd string, t model.Task, s model.Spider, u
 	if runtime.GOOS == constants.Windows {
 		cmd = exec.Command("cmd", "/C", cmdStr)
 	} else {
-		cmd = exec.Command("sh", "-c", cmdStr)
+		cmd = exec.Command("sh", "-c", GetLimitedCmd(cmdStr, s))
 	}
 
 	// 工作目录
 	cmd.Dir = cwd
 
+	// kill的时候，可以kill所有的子进程
+	if runtime.GOOS != constants.Windows {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+
+	// 资源限制，无法生效时任务失败
+	var cg *TaskCgroup
+	if HasCgroupLimits(s) {
+		g, err := NewTaskCgroup(t.Id, s)
+		if err != nil {
+			return SaveTaskLimitError(t, err)
+		}
+		cg = g
+		defer func() {
+			_ = cg.Remove()
+		}()
+		closeCgroup, err := cg.Attach(cmd)
+		if err != nil {
+			return SaveTaskLimitError(t, err)
+		}
+		defer closeCgroup()
+	}
+
 	// 日志配置
 	go SetLogConfig(wg, cmd, t, u)
 
@@ -394,19 +705,63 @@ func ExecuteShellCmd(cmdStr string, cwd string, t model.Task, s model.Spider, u
 	ch := utils.TaskExecChanMap.ChanBlocked(t.Id)
 	go FinishOrCancelTask(ch, cmd, s, t)
 
-	// kill的时候，可以kill所有的子进程
-	if runtime.GOOS != constants.Windows {
-		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	}
-
 	// 启动进程
 	if err := StartTaskProcess(cmd, t); err != nil {
 		return err
 	}
 
+	// 资源使用采样
+	stopMonitor := MonitorTaskResources(t, cmd.Process.Pid, u)
+
+	// 超时控制，进程退出后不再触发
+	var timeoutLock sync.Mutex
+	exited := false
+	timedOut := false
+	var timer *time.Timer
+	if timeout := GetTaskTimeout(t, s); timeout > 0 {
+		timer = time.AfterFunc(time.Duration(timeout)*time.Second, func() {
+			timeoutLock.Lock()
+			defer timeoutLock.Unlock()
+			if exited {
+				return
+			}
+			timedOut = true
+			select {
+			case ch <- constants.TaskTimeout:
+			default:
+			}
+		})
+	}
+
 	// 同步等待进程完成
-	if err := WaitTaskProcess(cmd, t, s); err != nil {
-		return err
+	waitErr := WaitTaskProcess(cmd, t, s)
+
+	// 进程已退出，之后触发的超时不再生效
+	timeoutLock.Lock()
+	exited = true
+	timeoutLock.Unlock()
+	if timer != nil {
+		timer.Stop()
+	}
+	stopMonitor()
+
+	// 内存超限被终止
+	if cg != nil && cg.IsOomKilled() {
+		if t, err := model.GetTask(t.Id); err == nil {
+			t.Error = ErrMsgMemoryLimitExceeded
+			t.Status = constants.StatusError
+			t.FinishTs = time.Now()
+			_ = t.Save()
+		}
+		return errors.New(ErrMsgMemoryLimitExceeded)
+	}
+	if waitErr != nil {
+		return waitErr
+	}
+
+	// 超时后进程自行退出
+	if timedOut {
+		return errors.New(fmt.Sprintf("task timed out after %d sec", GetTaskTimeout(t, s)))
 	}
 
 	// 如果返回值不为0，返回错误
@@ -421,13 +776,112 @@ func ExecuteShellCmd(cmdStr string, cwd string, t model.Task, s model.Spider, u
 	return nil
 }
 
-// 生成执行任务方法
-func GetExecuteTaskFunc(id int) func() {
-	return func() {
-		ExecuteTask(id)
+// 获取本节点的执行槽位，节点上的任务都由本节点的worker执行，本地计数即可
+func acquireNodeTaskSlot(maxRunners int) bool {
+	for {
+		count := nodeTaskCount.Load()
+		if maxRunners > 0 && count >= int64(maxRunners) {
+			return false
+		}
+		if nodeTaskCount.CAS(count, count+1) {
+			return true
+		}
 	}
 }
 
+// 爬虫并发限制检查锁
+func getSpiderSlotLock(spiderId bson.ObjectId) *sync.Mutex {
+	lock, _ := spiderSlotLocks.LoadOrStore(spiderId.Hex(), &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// 获取任务执行槽位，超出节点或爬虫的并发限制时返回false
+// 获取成功后，调用方需在任务状态置为运行中后调用 unlock，任务结束后调用 done
+func AcquireTaskSlot(t model.Task, s model.Spider, nodeId bson.ObjectId) (unlock func(), done func(), ok bool) {
+	// 节点并发限制
+	maxRunners := 0
+	if node, err := model.GetNode(nodeId); err == nil {
+		maxRunners = node.MaxRunners
+	}
+	if !acquireNodeTaskSlot(maxRunners) {
+		return nil, nil, false
+	}
+	done = func() {
+		nodeTaskCount.Dec()
+	}
+	unlock = func() {}
+
+	// 爬虫并发限制（跨节点，需加分布式锁）
+	if t.Type == constants.TaskTypeSpider && s.MaxConcurrency > 0 {
+		spiderLock := getSpiderSlotLock(s.Id)
+		spiderLock.Lock()
+		lockKey := "spider-concurrency:" + s.Id.Hex()
+		value, err := database.RedisClient.Lock(lockKey)
+		if err != nil {
+			spiderLock.Unlock()
+			done()
+			return nil, nil, false
+		}
+		count, err := s.GetRunningTaskCount()
+		if err != nil || count >= s.MaxConcurrency {
+			database.RedisClient.UnLock(lockKey, value)
+			spiderLock.Unlock()
+			done()
+			return nil, nil, false
+		}
+		unlock = func() {
+			database.RedisClient.UnLock(lockKey, value)
+			spiderLock.Unlock()
+		}
+	}
+
+	return unlock, done, true
+}
+
+// 超出并发限制后重新入队的等待时间，同一任务每次翻倍，最长30秒
+func GetTaskSlotBackoff(attempt int) time.Duration {
+	if attempt > 5 {
+		attempt = 5
+	}
+	delay := time.Second * time.Duration(1<<uint(attempt))
+	if delay > 30*time.Second {
+		delay = 30 * time.Second
+	}
+	return delay
+}
+
+// 超出并发限制的任务等待后放回队列头部，保持先后顺序，等待期间worker可执行其他任务
+func RequeueTaskAfterBackoff(t model.Task) time.Duration {
+	attempt := 0
+	if value, ok := taskSlotAttempts.Load(t.Id); ok {
+		attempt = value.(int)
+	}
+	taskSlotAttempts.Store(t.Id, attempt+1)
+
+	delay := GetTaskSlotBackoff(attempt)
+
+	// 推迟入队时间，等待期间对账不会将任务重复入队
+	if err := model.UpdateTaskEnqueueTs(t.Id, time.Now().Add(delay)); err != nil {
+		log.Errorf("update task enqueue ts error: %s, task id: %s", err.Error(), t.Id)
+		debug.PrintStack()
+	}
+
+	time.AfterFunc(delay, func() {
+		// 等待期间被取消的任务不再入队
+		latest, err := model.GetTask(t.Id)
+		if err != nil || latest.Status != constants.StatusPending {
+			taskSlotAttempts.Delete(t.Id)
+			return
+		}
+		if err := AssignTaskFront(latest); err != nil {
+			log.Errorf("requeue task error: %s, task id: %s", err.Error(), t.Id)
+			debug.PrintStack()
+		}
+	})
+	return delay
+}
+
+// 生成执行任务方法
 func GetWorkerPrefix(id int) string {
 	return "[Worker " + strconv.Itoa(id) + "] "
 }
@@ -460,20 +914,12 @@ func ScanErrorLogs(t model.Task) func() {
 }
 
 // 执行任务
-func ExecuteTask(id int) {
-	if flag, ok := LockList.Load(id); ok {
-		if flag.(bool) {
-			log.Debugf(GetWorkerPrefix(id) + "running tasks...")
-			return
-		}
-	}
-
+func ExecuteTask(id int, msg string) {
 	// 上锁
 	LockList.Store(id, true)
 
 	// 解锁（延迟执行）
 	defer func() {
-		LockList.Delete(id)
 		LockList.Store(id, false)
 	}()
 
@@ -481,32 +927,8 @@ func ExecuteTask(id int) {
 	tic := time.Now()
 
 	// 获取当前节点
-	//node, err := model.GetCurrentNode()
-	//if err != nil {
-	//	log.Errorf("execute task get current node error: %s", err.Error())
-	//	debug.PrintStack()
-	//	return
-	//}
 	node := local_node.CurrentNode()
 
-	// 节点队列
-	queueCur := "tasks:node:" + node.Id.Hex()
-
-	// 节点队列任务
-	var msg string
-	var err error
-	if msg, err = database.RedisClient.LPop(queueCur); err != nil {
-		// 节点队列没有任务，获取公共队列任务
-		queuePub := "tasks:public"
-		if msg, err = database.RedisClient.LPop(queuePub); err != nil {
-		}
-	}
-
-	// 如果没有获取到任务，返回
-	if msg == "" {
-		return
-	}
-
 	// 反序列化
 	tMsg := TaskMessage{}
 	if err := json.Unmarshal([]byte(msg), &tMsg); err != nil {
@@ -566,14 +988,34 @@ func ExecuteTask(id int) {
 		return
 	}
 
+	// 并发限制，超出限制则等待后重新入队
+	unlock, done, ok := AcquireTaskSlot(t, spider, node.Id)
+	if !ok {
+		delay := RequeueTaskAfterBackoff(t)
+		log.Debugf(GetWorkerPrefix(id) + "concurrency limit reached, requeue task (id:" + t.Id + ") in " + delay.String())
+		return
+	}
+	taskSlotAttempts.Delete(t.Id)
+	defer done()
+
+	// 原子地将任务由等待中置为运行中，已取消或已被其他执行器获取（重复入队）的任务丢弃消息
+	claimed, err := model.ClaimPendingTask(t.Id)
+	if err != nil || !claimed {
+		unlock()
+		log.Debugf(GetWorkerPrefix(id) + "task (id:" + t.Id + ") is not pending, drop task message")
+		return
+	}
+
 	// 任务赋值
 	t.NodeId = node.Id                                   // 任务节点信息
 	t.StartTs = time.Now()                               // 任务开始时间
 	t.Status = constants.StatusRunning                   // 任务状态
 	t.WaitDuration = t.StartTs.Sub(t.CreateTs).Seconds() // 等待时长
+	t.Stale = false                                      // 已开始运行
 
 	// 储存任务
 	_ = t.Save()
+	unlock()
 
 	// 发送 Web Hook 请求 (任务开始)
 	go SendWebHookRequest(user, t, spider)
@@ -622,10 +1064,12 @@ func ExecuteTask(id int) {
 	if err := ExecuteShellCmd(cmd, cwd, t, spider, user); err != nil {
 		log.Errorf(GetWorkerPrefix(id) + err.Error())
 
-		// 如果发生错误，则发送通知
+		// 如果发生错误，则按重试策略重试，最后一次尝试失败后才发送通知
 		t, _ = model.GetTask(t.Id)
-		if user.Setting.NotificationTrigger == constants.NotificationTriggerOnTaskEnd || user.Setting.NotificationTrigger == constants.NotificationTriggerOnTaskError {
-			SendNotifications(user, t, spider)
+		if !RetryTaskIfNeeded(t, spider) {
+			if user.Setting.NotificationTrigger == constants.NotificationTriggerOnTaskEnd || user.Setting.NotificationTrigger == constants.NotificationTriggerOnTaskError {
+				SendNotifications(user, t, spider)
+			}
 		}
 
 		// 发送 Web Hook 请求 (任务开始)
@@ -687,6 +1131,14 @@ func FinishUpTask(s model.Spider, t model.Task) {
 	go func() {
 		ScanErrorLogs(t)()
 	}()
+
+	// 触发工作流下游任务
+	go TriggerWorkflowDownstream(t)
+
+	// 定时任务运行结束（不再重试）后，放入延后入队的任务
+	if !ShouldRetryTask(t, s) {
+		go ReleaseDeferredScheduleTasks(t.ScheduleId)
+	}
 }
 
 func SpiderFileCheck(t model.Task, spider model.Spider) error {
@@ -713,13 +1165,13 @@ func SpiderFileCheck(t model.Task, spider model.Spider) error {
 	return nil
 }
 
-func GetTaskLog(id string, keyword string, page int, pageSize int) (logItems []model.LogItem, logTotal int, err error) {
+func GetTaskLog(id string, keyword string, levels []string, stream string, page int, pageSize int) (logItems []model.LogItem, logTotal int, err error) {
 	task, err := model.GetTask(id)
 	if err != nil {
 		return
 	}
 
-	logItems, logTotal, err = task.GetLogItems(keyword, page, pageSize)
+	logItems, logTotal, err = task.GetLogItems(keyword, levels, stream, page, pageSize)
 	if err != nil {
 		return logItems, logTotal, err
 	}
@@ -794,14 +1246,20 @@ func RestartTask(id string, uid bson.ObjectId) (err error) {
 	}
 
 	newTask := model.Task{
-		SpiderId:   oldTask.SpiderId,
-		NodeId:     oldTask.NodeId,
-		Cmd:        oldTask.Cmd,
-		Param:      oldTask.Param,
-		UserId:     uid,
-		RunType:    oldTask.RunType,
-		ScheduleId: bson.ObjectIdHex(constants.ObjectIdNull),
-		Type:       oldTask.Type,
+		SpiderId:     oldTask.SpiderId,
+		NodeId:       oldTask.NodeId,
+		Cmd:          oldTask.Cmd,
+		Param:        oldTask.Param,
+		UserId:       uid,
+		RunType:      oldTask.RunType,
+		ScheduleId:   bson.ObjectIdHex(constants.ObjectIdNull),
+		Type:         oldTask.Type,
+		Priority:     oldTask.Priority,
+		Timeout:      oldTask.Timeout,
+		ParentTaskId: oldTask.Id,
+		BatchId:      oldTask.BatchId,
+		Envs:         oldTask.Envs,
+		Timezone:     oldTask.Timezone,
 	}
 
 	// 加入任务队列
@@ -815,6 +1273,197 @@ func RestartTask(id string, uid bson.ObjectId) (err error) {
 	return nil
 }
 
+// 任务重试策略
+type RetryPolicy struct {
+	MaxRetries   int
+	RetryBackoff int
+	RetryOn      []string
+}
+
+// 获取任务的重试策略，定时任务的重试策略优先于爬虫的重试策略
+func GetTaskRetryPolicy(t model.Task, s model.Spider) RetryPolicy {
+	policy := RetryPolicy{
+		MaxRetries:   s.MaxRetries,
+		RetryBackoff: s.RetryBackoff,
+		RetryOn:      s.RetryOn,
+	}
+	if !utils.IsObjectIdNull(t.ScheduleId) {
+		if sch, err := model.GetSchedule(t.ScheduleId); err == nil && sch.MaxRetries > 0 {
+			policy = RetryPolicy{
+				MaxRetries:   sch.MaxRetries,
+				RetryBackoff: sch.RetryBackoff,
+				RetryOn:      sch.RetryOn,
+			}
+		}
+	}
+
+	// 默认只在任务错误时重试
+	if len(policy.RetryOn) == 0 {
+		policy.RetryOn = []string{constants.StatusError}
+	}
+	return policy
+}
+
+// 任务是否满足重试条件
+func ShouldRetryTask(t model.Task, s model.Spider) bool {
+	if t.Type != constants.TaskTypeSpider {
+		return false
+	}
+	policy := GetTaskRetryPolicy(t, s)
+	return t.RetryCount < policy.MaxRetries && utils.StringArrayContains(policy.RetryOn, t.Status)
+}
+
+// 根据重试策略重试任务，返回是否已发起重试
+func RetryTaskIfNeeded(t model.Task, s model.Spider) bool {
+	if !ShouldRetryTask(t, s) {
+		return false
+	}
+	policy := GetTaskRetryPolicy(t, s)
+
+	newTask := model.Task{
+		Id:           uuid.NewV4().String(),
+		SpiderId:     t.SpiderId,
+		NodeId:       t.NodeId,
+		Cmd:          t.Cmd,
+		Param:        t.Param,
+		UserId:       t.UserId,
+		RunType:      t.RunType,
+		ScheduleId:   t.ScheduleId,
+		Type:         t.Type,
+		Status:       constants.StatusPending,
+		Priority:     GetTaskPriority(t.Priority),
+		Timeout:      t.Timeout,
+		ParentTaskId: t.Id,
+		RetryCount:   t.RetryCount + 1,
+		BatchId:      t.BatchId,
+		Envs:         t.Envs,
+		Timezone:     t.Timezone,
+		Trigger:      t.Trigger,
+		WebhookId:    t.WebhookId,
+
+		// 工作流
+		WorkflowId:      t.WorkflowId,
+		WorkflowRunId:   t.WorkflowRunId,
+		WorkflowNodeKey: t.WorkflowNodeKey,
+		UpstreamTaskId:  t.UpstreamTaskId,
+	}
+
+	// 随机节点的任务、节点异常的任务重新放入公共队列，避免一直等待已离线的节点
+	if t.RunType == constants.RunTypeRandom || t.Status == constants.StatusAbnormal || newTask.NodeId.Hex() == "" {
+		newTask.NodeId = bson.ObjectIdHex(constants.ObjectIdNull)
+	}
+
+	// 重试间隔，每次重试翻倍，到达入队时间后由调度节点入队
+	delay := time.Duration(policy.RetryBackoff) * time.Second * time.Duration(1<<uint(t.RetryCount))
+	newTask.EnqueueTs = time.Now().Add(delay)
+	newTask.Delayed = delay > 0
+
+	// 将任务存入数据库
+	if err := model.AddTask(newTask); err != nil {
+		log.Errorf("add retry task error: %s", err.Error())
+		debug.PrintStack()
+		return false
+	}
+
+	log.Infof("retry task (id: %s) as task (id: %s) in %.0f sec, attempt %d of %d", t.Id, newTask.Id, delay.Seconds(), newTask.RetryCount, policy.MaxRetries)
+
+	// 无需等待的直接加入任务队列
+	if !newTask.Delayed {
+		if err := AssignTask(newTask); err != nil {
+			log.Errorf("assign retry task error: %s", err.Error())
+			debug.PrintStack()
+		}
+	}
+
+	return true
+}
+
+// 将已到达入队时间的延迟重试任务加入任务队列
+func EnqueueDueDelayedTasks() {
+	tasks, err := model.GetDueDelayedTasks(time.Now())
+	if err != nil {
+		return
+	}
+	for _, t := range tasks {
+		ok, err := model.ClaimDelayedTask(t.Id)
+		if err != nil || !ok {
+			continue
+		}
+		t.Delayed = false
+		if err := AssignTask(t); err != nil {
+			log.Errorf("assign retry task error: %s, task id: %s", err.Error(), t.Id)
+			debug.PrintStack()
+		}
+	}
+}
+
+// 初始化延迟重试任务入队（仅主节点），延迟信息保存在数据库中，主节点重启后不会丢失
+func InitDelayedTaskEnqueuer() error {
+	c := cron.New(cron.WithSeconds())
+	if _, err := c.AddFunc("@every 1s", func() {
+		// 多个主节点时只由调度节点入队
+		if !IsLeader() {
+			return
+		}
+		EnqueueDueDelayedTasks()
+	}); err != nil {
+		return err
+	}
+	c.Start()
+	return nil
+}
+
+// 将节点上等待中和运行中的任务置为异常，并按重试策略重试
+func UpdateTaskToAbnormal(nodeId bson.ObjectId) error {
+	query := bson.M{
+		"node_id": nodeId,
+		"status": bson.M{
+			"$in": []string{
+				constants.StatusPending,
+				constants.StatusRunning,
+			},
+		},
+	}
+	tasks, err := model.GetTaskList(query, 0, constants.Infinite, "-create_ts")
+	if err != nil {
+		return err
+	}
+
+	// 开启了节点故障重启的爬虫，任务重新放入公共队列
+	var abnormalTasks []model.Task
+	for _, t := range tasks {
+		spider, _ := t.GetSpider()
+		if GetNodeFailoverAction(t, spider) != NodeFailoverNone {
+			if err := RequeueNodeFailedTask(t); err == nil {
+				continue
+			}
+		}
+
+		// 等待中的任务从节点队列中移除，避免节点恢复后执行异常任务
+		if t.Status == constants.StatusPending {
+			_, _ = RemoveTaskMessage(t)
+		}
+		abnormalTasks = append(abnormalTasks, t)
+	}
+
+	if err := model.UpdateTaskToAbnormal(nodeId); err != nil {
+		return err
+	}
+
+	for _, t := range abnormalTasks {
+		t.Status = constants.StatusAbnormal
+		spider, err := t.GetSpider()
+		if err != nil {
+			continue
+		}
+		if !RetryTaskIfNeeded(t, spider) {
+			go TriggerWorkflowDownstream(t)
+			go ReleaseDeferredScheduleTasks(t.ScheduleId)
+		}
+	}
+	return nil
+}
+
 func AddTask(t model.Task) (string, error) {
 	// 生成任务ID
 	id := uuid.NewV4()
@@ -828,6 +1477,9 @@ func AddTask(t model.Task) (string, error) {
 		t.NodeId = bson.ObjectIdHex(constants.ObjectIdNull)
 	}
 
+	// 任务优先级
+	t.Priority = GetTaskPriority(t.Priority)
+
 	// 将任务存入数据库
 	if err := model.AddTask(t); err != nil {
 		log.Errorf(err.Error())
@@ -835,6 +1487,11 @@ func AddTask(t model.Task) (string, error) {
 		return t.Id, err
 	}
 
+	// 延后入队的任务等待定时任务上一次运行结束
+	if t.Deferred {
+		return t.Id, nil
+	}
+
 	// 加入任务队列
 	if err := AssignTask(t); err != nil {
 		log.Errorf(err.Error())
@@ -845,6 +1502,110 @@ func AddTask(t model.Task) (string, error) {
 	return t.Id, nil
 }
 
+// 校验运行方式和指定节点
+func ValidateTaskRunType(runType string, nodeIds []bson.ObjectId) error {
+	switch runType {
+	case constants.RunTypeAllNodes, constants.RunTypeRandom:
+	case constants.RunTypeSelectedNodes:
+		if len(nodeIds) == 0 {
+			return errors.New("node_ids should not be empty")
+		}
+		for _, nodeId := range nodeIds {
+			if !nodeId.Valid() {
+				return errors.New("invalid node_ids")
+			}
+		}
+	default:
+		return errors.New(fmt.Sprintf("invalid run type: %s", runType))
+	}
+	return nil
+}
+
+// 按运行方式创建任务（所有节点、随机、指定节点），返回创建的任务ID
+func AddTasksByRunType(t model.Task, runType string, nodeIds []bson.ObjectId) ([]string, error) {
+	if err := ValidateTaskRunType(runType, nodeIds); err != nil {
+		return nil, err
+	}
+
+	var nodes []bson.ObjectId
+	switch runType {
+	case constants.RunTypeAllNodes:
+		list, err := model.GetNodeList(nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range list {
+			nodes = append(nodes, node.Id)
+		}
+	case constants.RunTypeRandom:
+		nodes = []bson.ObjectId{""}
+	case constants.RunTypeSelectedNodes:
+		nodes = nodeIds
+	}
+
+	taskIds := []string{}
+	for _, nodeId := range nodes {
+		item := t
+		item.NodeId = nodeId
+		item.RunType = runType
+		id, err := AddTask(item)
+		if err != nil {
+			return taskIds, err
+		}
+		taskIds = append(taskIds, id)
+	}
+	return taskIds, nil
+}
+
+// 修改等待中任务的优先级
+func UpdateTaskPriority(id string, priority int) error {
+	// 校验优先级
+	if priority < constants.TaskPriorityHighest || priority > constants.TaskPriorityLowest {
+		return errors.New(fmt.Sprintf("priority should be between %d and %d", constants.TaskPriorityHighest, constants.TaskPriorityLowest))
+	}
+
+	// 获取任务
+	t, err := model.GetTask(id)
+	if err != nil {
+		log.Errorf("task not found, task id : %s, error: %s", id, err.Error())
+		debug.PrintStack()
+		return err
+	}
+
+	// 只有等待中的任务可以修改优先级
+	if t.Status != constants.StatusPending {
+		return errors.New("task is not pending")
+	}
+	if GetTaskPriority(t.Priority) == priority {
+		return nil
+	}
+
+	// 从原队列中移除任务消息
+	ok, err := RemoveTaskMessage(t)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// 任务已被执行器获取
+		return errors.New("task is not in queue")
+	}
+
+	// 保存任务优先级
+	t.Priority = priority
+	if err := t.Save(); err != nil {
+		return err
+	}
+
+	// 重新入队
+	if err := AssignTask(t); err != nil {
+		log.Errorf(err.Error())
+		debug.PrintStack()
+		return err
+	}
+
+	return nil
+}
+
 func GetTaskEmailMarkdownContent(t model.Task, s model.Spider) string {
 	n, _ := model.GetNode(t.NodeId)
 	errMsg := ""
@@ -1048,9 +1809,14 @@ func SendWebHookRequest(u model.User, t model.Task, s model.Spider) {
 
 func InitTaskExecutor() error {
 	// 构造任务执行器
-	c := cron.New(cron.WithSeconds())
 	Exec = &Executor{
-		Cron: c,
+		stopCh: make(chan struct{}),
+	}
+
+	// 迁移旧版本队列中的任务消息
+	if err := MigrateLegacyTaskQueues(local_node.CurrentNode().Id); err != nil {
+		log.Errorf("migrate legacy task queues error: %s", err.Error())
+		debug.PrintStack()
 	}
 
 	// 如果不允许主节点运行任务，则跳过
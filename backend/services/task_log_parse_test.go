@@ -0,0 +1,78 @@
+package services
+
+import (
+	"crawlab/constants"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestLogLineParser(t *testing.T) {
+	Convey("Test LogLineParser", t, func() {
+		p := &LogLineParser{}
+
+		Convey("parses scrapy logs", func() {
+			level, fields := p.Parse("2020-05-01 12:00:00 [scrapy.core.engine] INFO: Spider opened")
+			So(level, ShouldEqual, constants.LogLevelInfo)
+			So(fields["logger"], ShouldEqual, "scrapy.core.engine")
+		})
+		Convey("parses python logging formats", func() {
+			level, fields := p.Parse("ERROR:root:something failed")
+			So(level, ShouldEqual, constants.LogLevelError)
+			So(fields["logger"], ShouldEqual, "root")
+
+			level, fields = p.Parse("2020-05-01 12:00:00,123 - crawler - WARNING - slow response")
+			So(level, ShouldEqual, constants.LogLevelWarning)
+			So(fields["logger"], ShouldEqual, "crawler")
+
+			level, _ = p.Parse("2020-05-01 12:00:00,123 [DEBUG] fetching page")
+			So(level, ShouldEqual, constants.LogLevelDebug)
+		})
+		Convey("parses json lines", func() {
+			level, fields := p.Parse(`{"level": "warn", "msg": "retrying", "url": "http://example.com", "attempt": 2}`)
+			So(level, ShouldEqual, constants.LogLevelWarning)
+			So(fields["url"], ShouldEqual, "http://example.com")
+			So(fields["attempt"], ShouldEqual, 2)
+			So(fields["msg"], ShouldBeNil)
+		})
+		Convey("sanitizes json field names", func() {
+			_, fields := p.Parse(`{"msg": "schema", "$schema": "v1", "a.b": 1, "nested": {"$ref": "#", "list": [{"x.y": true}]}}`)
+			So(fields["_schema"], ShouldEqual, "v1")
+			So(fields["$schema"], ShouldBeNil)
+			So(fields["a_b"], ShouldEqual, 1)
+			nested := fields["nested"].(map[string]interface{})
+			So(nested["_ref"], ShouldEqual, "#")
+			item := nested["list"].([]interface{})[0].(map[string]interface{})
+			So(item["x_y"], ShouldBeTrue)
+		})
+		Convey("marks tracebacks as errors", func() {
+			level, _ := p.Parse("Traceback (most recent call last):")
+			So(level, ShouldEqual, constants.LogLevelError)
+			level, _ = p.Parse(`  File "main.py", line 1, in <module>`)
+			So(level, ShouldEqual, constants.LogLevelError)
+			level, _ = p.Parse("ValueError: invalid value")
+			So(level, ShouldEqual, constants.LogLevelError)
+			level, _ = p.Parse("done")
+			So(level, ShouldEqual, "")
+		})
+		Convey("plain lines have no level", func() {
+			level, fields := p.Parse("hello world")
+			So(level, ShouldEqual, "")
+			So(fields, ShouldBeNil)
+		})
+	})
+}
+
+func TestParseLogLevels(t *testing.T) {
+	Convey("Test ParseLogLevels", t, func() {
+		levels, err := ParseLogLevels("warn, ERROR")
+		So(err, ShouldBeNil)
+		So(levels, ShouldResemble, []string{constants.LogLevelWarning, constants.LogLevelError})
+
+		levels, err = ParseLogLevels("")
+		So(err, ShouldBeNil)
+		So(len(levels), ShouldEqual, 0)
+
+		_, err = ParseLogLevels("verbose")
+		So(err, ShouldNotBeNil)
+	})
+}
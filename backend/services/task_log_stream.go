@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"crawlab/constants"
+	"crawlab/database"
+	"crawlab/model"
+	"encoding/json"
+	"github.com/apex/log"
+	"github.com/gomodule/redigo/redis"
+	"sync"
+	"time"
+)
+
+// 实时日志推送间隔
+const taskLogPublishInterval = 200 * time.Millisecond
+
+// 尚未写入数据库的日志缓存在 Redis 中，供新的订阅者补齐
+const (
+	taskLogBufferSize = 1000
+	taskLogBufferTtl  = time.Hour
+)
+
+// 从数据库补齐日志时每次读取的数量
+const taskLogCatchUpPageSize = 1000
+
+// 缓存中的日志与已发送的日志不连续时（缓存已丢弃的日志尚未写入数据库），等待写入数据库的最长时间
+const taskLogGapWait = 15 * time.Second
+
+// 实时日志消息，End 表示日志已全部写入数据库
+type TaskLogStreamMessage struct {
+	Items []model.LogItem `json:"items"`
+	End   bool            `json:"end"`
+}
+
+// 任务实时日志频道
+func GetTaskLogChannel(taskId string) string {
+	return "tasks:log:" + taskId
+}
+
+func getTaskLogBufferKey(taskId string) string {
+	return "tasks:log-buffer:" + taskId
+}
+
+// 实时日志发布者，由执行任务的节点定时批量发布到 Redis
+type TaskLogPublisher struct {
+	taskId string
+	mu     sync.Mutex
+	items  []model.LogItem
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func NewTaskLogPublisher(taskId string) *TaskLogPublisher {
+	p := &TaskLogPublisher{
+		taskId: taskId,
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// 加入待发布的日志
+func (p *TaskLogPublisher) Publish(item model.LogItem) {
+	p.mu.Lock()
+	p.items = append(p.items, item)
+	p.mu.Unlock()
+}
+
+func (p *TaskLogPublisher) run() {
+	defer close(p.doneCh)
+
+	ticker := time.NewTicker(taskLogPublishInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.flush(false)
+		case <-p.stopCh:
+			p.flush(true)
+			return
+		}
+	}
+}
+
+func (p *TaskLogPublisher) flush(end bool) {
+	p.mu.Lock()
+	items := p.items
+	p.items = nil
+	p.mu.Unlock()
+
+	if len(items) == 0 && !end {
+		return
+	}
+
+	if len(items) > 0 {
+		values := make([]string, 0, len(items))
+		for _, item := range items {
+			value, _ := json.Marshal(item)
+			values = append(values, string(value))
+		}
+		if err := database.RedisClient.RPushCapped(getTaskLogBufferKey(p.taskId), values, taskLogBufferSize, taskLogBufferTtl); err != nil {
+			log.Warnf("buffer task log error: %s, task id: %s", err.Error(), p.taskId)
+		}
+	}
+
+	msg, _ := json.Marshal(TaskLogStreamMessage{Items: items, End: end})
+	if _, err := database.RedisClient.Publish(GetTaskLogChannel(p.taskId), string(msg)); err != nil {
+		log.Warnf("publish task log error: %s, task id: %s", err.Error(), p.taskId)
+	}
+}
+
+// 发布剩余日志及结束消息（日志全部写入数据库后调用）
+func (p *TaskLogPublisher) Close() {
+	close(p.stopCh)
+	<-p.doneCh
+}
+
+// 获取 Redis 中缓存的日志
+func getBufferedTaskLogs(taskId string) ([]model.LogItem, error) {
+	values, err := database.RedisClient.LRange(getTaskLogBufferKey(taskId), 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]model.LogItem, 0, len(values))
+	for _, value := range values {
+		var item model.LogItem
+		if err := json.Unmarshal([]byte(value), &item); err != nil {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// 过滤出 seq 之后的日志
+func filterTaskLogsAfter(items []model.LogItem, seq int64) []model.LogItem {
+	var results []model.LogItem
+	for _, item := range items {
+		if item.Seq > seq {
+			results = append(results, item)
+		}
+	}
+	return results
+}
+
+// seq 之后的日志是否不连续
+func hasTaskLogGap(items []model.LogItem, seq int64) bool {
+	return len(items) > 0 && items[0].Seq > seq+1
+}
+
+// 任务是否已结束
+func isTaskFinished(taskId string) bool {
+	t, err := model.GetTask(taskId)
+	if err != nil {
+		return false
+	}
+	return t.Status != constants.StatusPending && t.Status != constants.StatusRunning
+}
+
+// 实时推送任务日志：先发送 seq 之后已有的日志，再推送新产生的日志，任务结束后返回
+// send 收到空列表时表示心跳
+func StreamTaskLog(ctx context.Context, taskId string, seq int64, send func(items []model.LogItem) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// 先订阅，避免补齐期间产生的日志丢失
+	live := make(chan TaskLogStreamMessage, 256)
+	consume := func(message redis.Message) error {
+		var msg TaskLogStreamMessage
+		if err := json.Unmarshal(message.Data, &msg); err != nil {
+			return err
+		}
+		select {
+		case live <- msg:
+		default:
+			// 消费过慢时丢弃，之后按 seq 补齐
+		}
+		return nil
+	}
+	if err := database.RedisClient.Subscribe(ctx, consume, GetTaskLogChannel(taskId)); err != nil {
+		return err
+	}
+
+	last := seq
+	sendItems := func(items []model.LogItem) error {
+		if len(items) == 0 {
+			return nil
+		}
+		if err := send(items); err != nil {
+			return err
+		}
+		last = items[len(items)-1].Seq
+		return nil
+	}
+
+	// 从数据库及 Redis 缓存补齐 last 之后的日志
+	catchUp := func() error {
+		deadline := time.Now().Add(taskLogGapWait)
+		for {
+			for {
+				items, err := model.GetLogItemsAfterSeq(taskId, last, taskLogCatchUpPageSize)
+				if err != nil {
+					return err
+				}
+				if err := sendItems(items); err != nil {
+					return err
+				}
+				if len(items) < taskLogCatchUpPageSize {
+					break
+				}
+			}
+			buffered, err := getBufferedTaskLogs(taskId)
+			if err != nil {
+				return err
+			}
+			items := filterTaskLogsAfter(buffered, last)
+			if !hasTaskLogGap(items, last) {
+				return sendItems(items)
+			}
+
+			// 缓存已丢弃的日志尚未写入数据库，等待写入后再从数据库读取
+			if time.Now().After(deadline) {
+				log.Warnf("task log lines after seq %d are missing, task id: %s", last, taskId)
+				return sendItems(items)
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+		}
+	}
+
+	finished := isTaskFinished(taskId)
+	if err := catchUp(); err != nil {
+		return err
+	}
+	if finished {
+		return nil
+	}
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-live:
+			items := filterTaskLogsAfter(msg.Items, last)
+			if hasTaskLogGap(items, last) {
+				// 有日志未收到，先补齐
+				if err := catchUp(); err != nil {
+					return err
+				}
+				items = filterTaskLogsAfter(items, last)
+			}
+			if err := sendItems(items); err != nil {
+				return err
+			}
+			if msg.End {
+				return catchUp()
+			}
+		case <-ticker.C:
+			// 执行节点异常退出时收不到结束消息，任务结束后补齐并返回
+			if isTaskFinished(taskId) {
+				return catchUp()
+			}
+			if err := send(nil); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package services
+
+import (
+	"crawlab/model"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestTaskLogGap(t *testing.T) {
+	Convey("Test task log gap", t, func() {
+		items := func(seqs ...int64) (list []model.LogItem) {
+			for _, seq := range seqs {
+				list = append(list, model.LogItem{Seq: seq})
+			}
+			return list
+		}
+
+		So(len(filterTaskLogsAfter(items(3, 4, 5), 3)), ShouldEqual, 2)
+		So(hasTaskLogGap(filterTaskLogsAfter(items(3, 4, 5), 2), 2), ShouldBeFalse)
+		So(hasTaskLogGap(items(5, 6), 3), ShouldBeTrue)
+		So(hasTaskLogGap(nil, 3), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,316 @@
+package services
+
+import (
+	"bufio"
+	"crawlab/constants"
+	"crawlab/model"
+	"crawlab/utils"
+	"errors"
+	"github.com/apex/log"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// /proc 中 CPU 时间的单位（USER_HZ）
+const clockTicksPerSecond = 100
+
+// 进程的 /proc/<pid>/stat 信息
+type procStat struct {
+	Pid   int
+	Pgrp  int
+	Ticks uint64 // utime + stime
+	Rss   int64  // 字节
+}
+
+// 解析 /proc/<pid>/stat，进程名可能包含空格和括号，因此从最后一个右括号后开始解析
+func parseProcStat(content string) (procStat, error) {
+	var stat procStat
+
+	start := strings.Index(content, "(")
+	end := strings.LastIndex(content, ")")
+	if start < 0 || end < start {
+		return stat, errors.New("invalid proc stat")
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(content[:start]))
+	if err != nil {
+		return stat, err
+	}
+
+	// 右括号后的字段从 state（第3个字段）开始
+	fields := strings.Fields(content[end+1:])
+	if len(fields) < 22 {
+		return stat, errors.New("invalid proc stat")
+	}
+	pgrp, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return stat, err
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return stat, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return stat, err
+	}
+	rss, err := strconv.ParseInt(fields[21], 10, 64)
+	if err != nil {
+		return stat, err
+	}
+
+	stat.Pid = pid
+	stat.Pgrp = pgrp
+	stat.Ticks = utime + stime
+	stat.Rss = rss * int64(os.Getpagesize())
+	return stat, nil
+}
+
+// 读取 /proc/<pid>/io 中的实际读写字节数
+func readProcIO(pid int) (readBytes int64, writeBytes int64, err error) {
+	f, err := os.Open(filepath.Join("/proc", strconv.Itoa(pid), "io"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer utils.Close(f)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, _ := strconv.ParseInt(fields[1], 10, 64)
+		switch fields[0] {
+		case "read_bytes:":
+			readBytes = value
+		case "write_bytes:":
+			writeBytes = value
+		}
+	}
+	return readBytes, writeBytes, nil
+}
+
+// 获取进程组内所有进程的信息
+func readProcessGroupStats(pgid int) ([]procStat, error) {
+	dirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []procStat
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join("/proc", dir.Name(), "stat"))
+		if err != nil {
+			// 进程已退出
+			continue
+		}
+		stat, err := parseProcStat(string(content))
+		if err != nil || stat.Pgrp != pgid {
+			continue
+		}
+		stat.Pid = pid
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// 任务资源使用统计及采样序列
+type TaskMetricsData struct {
+	TaskId   string                  `json:"task_id"`
+	Interval int                     `json:"interval"` // 采样间隔（秒）
+	Summary  model.TaskResourceUsage `json:"summary"`
+	Metrics  []model.TaskMetric      `json:"metrics"`
+}
+
+// 任务资源使用采样器，统计任务进程组的 CPU、内存和 IO
+type TaskResourceSampler struct {
+	Pgid  int
+	Usage model.TaskResourceUsage
+
+	lastTs    time.Time
+	lastTicks map[int]uint64
+	ioBytes   map[int][2]int64 // 各进程累计读写字节数，进程退出后仍计入
+	count     int
+	cpuSum    float64
+	memorySum int64
+}
+
+func NewTaskResourceSampler(pgid int, startTs time.Time) *TaskResourceSampler {
+	return &TaskResourceSampler{
+		Pgid:      pgid,
+		lastTs:    startTs,
+		lastTicks: map[int]uint64{},
+		ioBytes:   map[int][2]int64{},
+	}
+}
+
+// 根据进程组信息生成采样点，并更新峰值和平均值
+func (s *TaskResourceSampler) update(stats []procStat, ioBytes map[int][2]int64, ts time.Time) model.TaskMetric {
+	var deltaTicks uint64
+	var memory int64
+	ticks := make(map[int]uint64, len(stats))
+	for _, stat := range stats {
+		// 新进程从启动开始计算
+		if last, ok := s.lastTicks[stat.Pid]; ok && stat.Ticks >= last {
+			deltaTicks += stat.Ticks - last
+		} else {
+			deltaTicks += stat.Ticks
+		}
+		ticks[stat.Pid] = stat.Ticks
+		memory += stat.Rss
+	}
+	for pid, value := range ioBytes {
+		s.ioBytes[pid] = value
+	}
+
+	var cpu float64
+	if elapsed := ts.Sub(s.lastTs).Seconds(); elapsed > 0 {
+		cpu = float64(deltaTicks) / clockTicksPerSecond / elapsed * 100
+	}
+	s.lastTs = ts
+	s.lastTicks = ticks
+
+	var readBytes, writeBytes int64
+	for _, value := range s.ioBytes {
+		readBytes += value[0]
+		writeBytes += value[1]
+	}
+
+	// 统计
+	s.count++
+	s.cpuSum += cpu
+	s.memorySum += memory
+	if cpu > s.Usage.PeakCpu {
+		s.Usage.PeakCpu = cpu
+	}
+	if memory > s.Usage.PeakMemory {
+		s.Usage.PeakMemory = memory
+	}
+	s.Usage.AvgCpu = s.cpuSum / float64(s.count)
+	s.Usage.AvgMemory = s.memorySum / int64(s.count)
+	s.Usage.ReadBytes = readBytes
+	s.Usage.WriteBytes = writeBytes
+
+	return model.TaskMetric{
+		Cpu:          cpu,
+		Memory:       memory,
+		ReadBytes:    readBytes,
+		WriteBytes:   writeBytes,
+		ProcessCount: len(stats),
+		Ts:           ts,
+	}
+}
+
+// 采样一次
+func (s *TaskResourceSampler) Sample() (model.TaskMetric, error) {
+	stats, err := readProcessGroupStats(s.Pgid)
+	if err != nil {
+		return model.TaskMetric{}, err
+	}
+	ioBytes := map[int][2]int64{}
+	for _, stat := range stats {
+		readBytes, writeBytes, err := readProcIO(stat.Pid)
+		if err != nil {
+			continue
+		}
+		ioBytes[stat.Pid] = [2]int64{readBytes, writeBytes}
+	}
+	return s.update(stats, ioBytes, time.Now()), nil
+}
+
+// 获取资源采样间隔
+func GetTaskMetricsInterval() time.Duration {
+	interval := viper.GetInt("task.metricsInterval")
+	if interval <= 0 {
+		interval = 5
+	}
+	return time.Duration(interval) * time.Second
+}
+
+// 定时采样任务进程组的资源使用（仅支持 Linux），返回停止函数
+// 停止函数会在保存最终统计后返回
+func MonitorTaskResources(t model.Task, pid int, u model.User) func() {
+	if runtime.GOOS != constants.Linux {
+		return func() {}
+	}
+
+	// 采样点过期时间与日志一致
+	expireDuration := u.Setting.LogExpireDuration
+	if expireDuration == 0 {
+		expireDuration = 3600 * 24
+	}
+
+	sampler := NewTaskResourceSampler(pid, time.Now())
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+
+	go func() {
+		defer close(doneCh)
+
+		ticker := time.NewTicker(GetTaskMetricsInterval())
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				metric, err := sampler.Sample()
+				if err != nil {
+					log.Warnf("sample task resources error: %s, task id: %s", err.Error(), t.Id)
+					continue
+				}
+				metric.TaskId = t.Id
+				metric.ExpireTs = time.Now().Add(time.Duration(expireDuration) * time.Second)
+				_ = metric.Add()
+				_ = model.UpdateTaskResourceUsage(t.Id, sampler.Usage)
+			case <-stopCh:
+				// 保存最终统计
+				if sampler.count > 0 {
+					_ = model.UpdateTaskResourceUsage(t.Id, sampler.Usage)
+				}
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stopCh)
+		<-doneCh
+	}
+}
+
+// 获取任务资源使用统计及采样序列
+func GetTaskMetrics(id string) (TaskMetricsData, error) {
+	var data TaskMetricsData
+
+	t, err := model.GetTask(id)
+	if err != nil {
+		return data, err
+	}
+	metrics, err := model.GetTaskMetricList(id)
+	if err != nil {
+		return data, err
+	}
+
+	data.TaskId = t.Id
+	data.Interval = int(GetTaskMetricsInterval().Seconds())
+	data.Summary = model.TaskResourceUsage{
+		PeakCpu:    t.PeakCpu,
+		AvgCpu:     t.AvgCpu,
+		PeakMemory: t.PeakMemory,
+		AvgMemory:  t.AvgMemory,
+		ReadBytes:  t.ReadBytes,
+		WriteBytes: t.WriteBytes,
+	}
+	data.Metrics = metrics
+	return data, nil
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseProcStat(t *testing.T) {
+	Convey("Test parseProcStat", t, func() {
+		Convey("parses process name with spaces and brackets", func() {
+			content := "1234 (scrapy (x) y) S 1 1230 1230 0 -1 4194560 100 0 0 0 250 50 0 0 20 0 3 0 100 1000000 512 18446744073709551615"
+			stat, err := parseProcStat(content)
+			So(err, ShouldBeNil)
+			So(stat.Pid, ShouldEqual, 1234)
+			So(stat.Pgrp, ShouldEqual, 1230)
+			So(stat.Ticks, ShouldEqual, 300)
+			So(stat.Rss, ShouldEqual, 512*int64(os.Getpagesize()))
+		})
+		Convey("invalid content is rejected", func() {
+			_, err := parseProcStat("1234 scrapy S 1")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestTaskResourceSampler(t *testing.T) {
+	Convey("Test TaskResourceSampler", t, func() {
+		start := time.Now()
+		sampler := NewTaskResourceSampler(100, start)
+
+		// 第一次采样：1秒内使用了 50 个 tick（0.5 核）
+		m := sampler.update([]procStat{
+			{Pid: 100, Ticks: 20, Rss: 1000},
+			{Pid: 101, Ticks: 30, Rss: 3000},
+		}, map[int][2]int64{100: {10, 20}}, start.Add(time.Second))
+		So(m.Cpu, ShouldEqual, 50)
+		So(m.Memory, ShouldEqual, 4000)
+		So(m.ProcessCount, ShouldEqual, 2)
+
+		// 第二次采样：101 已退出，其 IO 仍计入
+		m = sampler.update([]procStat{
+			{Pid: 100, Ticks: 220, Rss: 2000},
+		}, map[int][2]int64{100: {30, 40}, 101: {5, 5}}, start.Add(2*time.Second))
+		So(m.Cpu, ShouldEqual, 200)
+		So(m.Memory, ShouldEqual, 2000)
+
+		m = sampler.update(nil, map[int][2]int64{}, start.Add(3*time.Second))
+		So(m.ReadBytes, ShouldEqual, 35)
+		So(m.WriteBytes, ShouldEqual, 45)
+
+		So(sampler.Usage.PeakCpu, ShouldEqual, 200)
+		So(sampler.Usage.AvgCpu, ShouldAlmostEqual, 250.0/3)
+		So(sampler.Usage.PeakMemory, ShouldEqual, 4000)
+		So(sampler.Usage.AvgMemory, ShouldEqual, 2000)
+	})
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/database"
+	"crawlab/lib/cron"
+	"crawlab/model"
+	"encoding/json"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo/bson"
+	"github.com/spf13/viper"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// 对账时确认任务确实不在队列中的等待时间，避免将刚被取出、尚未置为运行中的任务重复入队
+const reconcileConfirmDelay = 3 * time.Second
+
+// 任务队列对账锁
+var reconcileLock sync.Mutex
+
+// 任务队列对账结果
+type TaskReconcileResult struct {
+	PendingCount int      `json:"pending_count"` // 等待中的任务数
+	RequeuedIds  []string `json:"requeued_ids"`  // 重新入队的任务
+	StaleIds     []string `json:"stale_ids"`     // 新标记为等待过长的任务
+}
+
+// 获取任务入队时间，旧数据没有入队时间时取创建时间
+func GetTaskEnqueueTs(t model.Task) time.Time {
+	if t.EnqueueTs.IsZero() {
+		return t.CreateTs
+	}
+	return t.EnqueueTs
+}
+
+// 任务是否应当在任务队列中（延后入队、延迟重试和未到入队时间的任务不在队列中）
+func IsTaskExpectedInQueue(t model.Task, now time.Time) bool {
+	return t.Status == constants.StatusPending && !t.Deferred && !t.Delayed && !GetTaskEnqueueTs(t).After(now)
+}
+
+// 任务入队已超过 minAge，应当能在队列中找到
+func IsTaskDueForReconcile(t model.Task, now time.Time, minAge time.Duration) bool {
+	return IsTaskExpectedInQueue(t, now) && !GetTaskEnqueueTs(t).Add(minAge).After(now)
+}
+
+// 任务在队列中等待的时间是否超过 staleAge
+func IsTaskStale(t model.Task, now time.Time, staleAge time.Duration) bool {
+	if staleAge <= 0 || !IsTaskExpectedInQueue(t, now) {
+		return false
+	}
+	return GetTaskEnqueueTs(t).Add(staleAge).Before(now)
+}
+
+// 获取队列中的任务ID
+func getQueuedTaskIds(queue string) (map[string]bool, error) {
+	values, err := database.RedisClient.LRange(queue, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool, len(values))
+	for _, value := range values {
+		var msg TaskMessage
+		if err := json.Unmarshal([]byte(value), &msg); err != nil {
+			continue
+		}
+		ids[msg.Id] = true
+	}
+	return ids, nil
+}
+
+// 找出不在队列中的任务，按队列分组
+func getMissingTasks(tasks []model.Task) (map[string][]model.Task, error) {
+	byQueue := map[string][]model.Task{}
+	for _, t := range tasks {
+		queue := GetTaskQueueName(t)
+		byQueue[queue] = append(byQueue[queue], t)
+	}
+
+	missing := map[string][]model.Task{}
+	for queue, list := range byQueue {
+		ids, err := getQueuedTaskIds(queue)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range list {
+			if !ids[t.Id] {
+				missing[queue] = append(missing[queue], t)
+			}
+		}
+	}
+	return missing, nil
+}
+
+// 对比数据库中等待中的任务与 Redis 任务队列，将丢失的任务重新入队，并标记等待过长的任务
+func ReconcileTaskQueues() (TaskReconcileResult, error) {
+	reconcileLock.Lock()
+	defer reconcileLock.Unlock()
+
+	result := TaskReconcileResult{
+		RequeuedIds: []string{},
+		StaleIds:    []string{},
+	}
+
+	minAge := time.Duration(viper.GetInt("task.reconcileMinAge")) * time.Second
+	staleAge := time.Duration(viper.GetInt("task.pendingStaleAge")) * time.Second
+
+	tasks, err := model.GetTaskList(bson.M{"status": constants.StatusPending}, 0, constants.Infinite, "+create_ts")
+	if err != nil {
+		return result, err
+	}
+	result.PendingCount = len(tasks)
+
+	// 标记等待过长的任务
+	now := time.Now()
+	var dueTasks []model.Task
+	deferredSchedules := map[bson.ObjectId]bool{}
+	for _, t := range tasks {
+		if IsTaskStale(t, now, staleAge) && !t.Stale {
+			if err := model.MarkTaskStale(t.Id); err == nil {
+				result.StaleIds = append(result.StaleIds, t.Id)
+			}
+		}
+		if t.Deferred {
+			// 延后入队的任务不在队列中，检查定时任务上一次运行是否已结束
+			deferredSchedules[t.ScheduleId] = true
+			continue
+		}
+		if IsTaskDueForReconcile(t, now, minAge) {
+			dueTasks = append(dueTasks, t)
+		}
+	}
+	for scheduleId := range deferredSchedules {
+		ReleaseDeferredScheduleTasks(scheduleId)
+	}
+
+	// 第一次检查
+	missing, err := getMissingTasks(dueTasks)
+	if err != nil {
+		return result, err
+	}
+	if len(missing) == 0 {
+		return result, nil
+	}
+
+	// 稍后再次确认，仍然等待中且不在队列中的任务才重新入队
+	time.Sleep(reconcileConfirmDelay)
+	var candidates []model.Task
+	for _, list := range missing {
+		for _, t := range list {
+			latest, err := model.GetTask(t.Id)
+			if err != nil || latest.Status != constants.StatusPending {
+				continue
+			}
+			candidates = append(candidates, latest)
+		}
+	}
+	missing, err = getMissingTasks(candidates)
+	if err != nil {
+		return result, err
+	}
+	for _, list := range missing {
+		for _, t := range list {
+			if err := AssignTask(t); err != nil {
+				log.Errorf("requeue task error: %s, task id: %s", err.Error(), t.Id)
+				debug.PrintStack()
+				continue
+			}
+			result.RequeuedIds = append(result.RequeuedIds, t.Id)
+		}
+	}
+
+	if len(result.RequeuedIds) > 0 {
+		log.Warnf("requeued %d pending tasks missing from task queues", len(result.RequeuedIds))
+	}
+	return result, nil
+}
+
+// 初始化定时任务队列对账（仅主节点）
+func InitTaskReconciler() error {
+	interval := viper.GetInt("task.reconcileInterval")
+	if interval <= 0 {
+		log.Info("task queue reconciler is switched off")
+		return nil
+	}
+
+	c := cron.New(cron.WithSeconds())
+	if _, err := c.AddFunc(fmt.Sprintf("@every %ds", interval), func() {
+		// 多个主节点时只由调度节点对账
+		if !IsLeader() {
+			return
+		}
+		if _, err := ReconcileTaskQueues(); err != nil {
+			log.Errorf("reconcile task queues error: %s", err.Error())
+			debug.PrintStack()
+		}
+	}); err != nil {
+		return err
+	}
+	c.Start()
+	return nil
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestTaskReconcile(t *testing.T) {
+	Convey("Test task reconcile helpers", t, func() {
+		now := time.Now()
+
+		Convey("enqueue ts falls back to create ts", func() {
+			task := model.Task{CreateTs: now.Add(-time.Hour)}
+			So(GetTaskEnqueueTs(task), ShouldEqual, task.CreateTs)
+			task.EnqueueTs = now
+			So(GetTaskEnqueueTs(task), ShouldEqual, now)
+		})
+
+		Convey("recently enqueued and delayed retry tasks are not reconciled", func() {
+			task := model.Task{Status: constants.StatusPending, EnqueueTs: now.Add(-10 * time.Second)}
+			So(IsTaskDueForReconcile(task, now, time.Minute), ShouldBeFalse)
+			task.EnqueueTs = now.Add(time.Hour)
+			So(IsTaskDueForReconcile(task, now, time.Minute), ShouldBeFalse)
+			task.EnqueueTs = now.Add(-2 * time.Minute)
+			So(IsTaskDueForReconcile(task, now, time.Minute), ShouldBeTrue)
+			task.Status = constants.StatusRunning
+			So(IsTaskDueForReconcile(task, now, time.Minute), ShouldBeFalse)
+		})
+
+		Convey("stale pending tasks", func() {
+			task := model.Task{Status: constants.StatusPending, EnqueueTs: now.Add(-2 * time.Hour)}
+			So(IsTaskStale(task, now, time.Hour), ShouldBeTrue)
+			So(IsTaskStale(task, now, 3*time.Hour), ShouldBeFalse)
+			So(IsTaskStale(task, now, 0), ShouldBeFalse)
+		})
+
+		Convey("tasks kept out of the queue are not stale or reconciled", func() {
+			deferred := model.Task{Status: constants.StatusPending, Deferred: true, EnqueueTs: now.Add(-2 * time.Hour)}
+			So(IsTaskStale(deferred, now, time.Hour), ShouldBeFalse)
+			So(IsTaskDueForReconcile(deferred, now, time.Minute), ShouldBeFalse)
+
+			delayed := model.Task{Status: constants.StatusPending, Delayed: true, EnqueueTs: now.Add(-2 * time.Hour)}
+			So(IsTaskStale(delayed, now, time.Hour), ShouldBeFalse)
+			So(IsTaskDueForReconcile(delayed, now, time.Minute), ShouldBeFalse)
+
+			future := model.Task{Status: constants.StatusPending, CreateTs: now.Add(-2 * time.Hour), EnqueueTs: now.Add(time.Hour)}
+			So(IsTaskStale(future, now, time.Hour), ShouldBeFalse)
+		})
+	})
+}
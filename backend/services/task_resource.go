@@ -0,0 +1,130 @@
+package services
+
+import (
+	"bufio"
+	"crawlab/constants"
+	"crawlab/model"
+	"crawlab/utils"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// cgroup v2 挂载点
+const cgroupRoot = "/sys/fs/cgroup"
+
+// 内存超限导致进程被终止的错误信息
+const ErrMsgMemoryLimitExceeded = "killed: memory limit exceeded"
+
+// 任务的 cgroup（cgroup v2），用于限制 CPU 和内存
+type TaskCgroup struct {
+	Path string
+}
+
+// 当前系统是否支持 cgroup v2
+func IsCgroupV2Available() bool {
+	return runtime.GOOS == constants.Linux && utils.Exists(filepath.Join(cgroupRoot, "cgroup.controllers"))
+}
+
+// 爬虫是否设置了 CPU 或内存限制
+func HasCgroupLimits(s model.Spider) bool {
+	return s.CpuLimit > 0 || s.MemoryLimit > 0
+}
+
+// 为任务创建 cgroup 并写入资源限制
+func NewTaskCgroup(taskId string, s model.Spider) (*TaskCgroup, error) {
+	if !IsCgroupV2Available() {
+		return nil, errors.New("cgroup v2 is not available")
+	}
+
+	// 父 cgroup，需开启 cpu 和 memory 控制器
+	parent := viper.GetString("task.cgroupPath")
+	if parent == "" {
+		parent = filepath.Join(cgroupRoot, "crawlab")
+	}
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, err
+	}
+	_ = ioutil.WriteFile(filepath.Join(filepath.Dir(parent), "cgroup.subtree_control"), []byte("+cpu +memory"), 0644)
+	if err := ioutil.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+cpu +memory"), 0644); err != nil {
+		return nil, err
+	}
+
+	g := &TaskCgroup{Path: filepath.Join(parent, "task-"+taskId)}
+	if err := os.MkdirAll(g.Path, 0755); err != nil {
+		return nil, err
+	}
+
+	// 内存限制（MB），禁用 swap 以便超限时直接终止
+	if s.MemoryLimit > 0 {
+		if err := g.write("memory.max", strconv.FormatInt(int64(s.MemoryLimit)*1024*1024, 10)); err != nil {
+			_ = g.Remove()
+			return nil, err
+		}
+		_ = g.write("memory.swap.max", "0")
+	}
+
+	// CPU 限制（核数），周期 100ms
+	if s.CpuLimit > 0 {
+		quota := int64(s.CpuLimit * 100000)
+		if err := g.write("cpu.max", fmt.Sprintf("%d 100000", quota)); err != nil {
+			_ = g.Remove()
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+func (g *TaskCgroup) write(file string, value string) error {
+	return ioutil.WriteFile(filepath.Join(g.Path, file), []byte(value), 0644)
+}
+
+// 设置命令在 cgroup 中启动，返回的函数在进程启动后调用
+func (g *TaskCgroup) Attach(cmd *exec.Cmd) (func(), error) {
+	return attachCgroup(cmd, g.Path)
+}
+
+// 是否发生过内存超限终止
+func (g *TaskCgroup) IsOomKilled() bool {
+	f, err := os.Open(filepath.Join(g.Path, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer utils.Close(f)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			n, _ := strconv.Atoi(fields[1])
+			return n > 0
+		}
+	}
+	return false
+}
+
+// 删除 cgroup（进程全部退出后才能删除）
+func (g *TaskCgroup) Remove() error {
+	if err := os.Remove(g.Path); err != nil {
+		log.Warnf("remove task cgroup error: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// 为命令加上文件数限制（rlimit），整条命令在设置限制后的 shell 中执行，设置失败时命令不执行
+func GetLimitedCmd(cmdStr string, s model.Spider) string {
+	if runtime.GOOS == constants.Windows || s.MaxOpenFiles <= 0 {
+		return cmdStr
+	}
+	return fmt.Sprintf("ulimit -n %d && exec sh -c %s", s.MaxOpenFiles, utils.ShellQuote(cmdStr))
+}
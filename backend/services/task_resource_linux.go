@@ -0,0 +1,24 @@
+package services
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// 进程直接在 cgroup 中创建（clone3 CLONE_INTO_CGROUP，需 Linux 5.7+），启动后派生的子进程都受限制
+// 返回的函数在进程启动后调用，关闭 cgroup 目录
+func attachCgroup(cmd *exec.Cmd, path string) (func(), error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(f.Fd())
+	return func() {
+		_ = f.Close()
+	}, nil
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"crawlab/model"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetLimitedCmd(t *testing.T) {
+	Convey("Test GetLimitedCmd", t, func() {
+		Convey("no limit", func() {
+			So(GetLimitedCmd("python main.py", model.Spider{}), ShouldEqual, "python main.py")
+		})
+		Convey("limit applies to the whole command", func() {
+			cmdStr := GetLimitedCmd("true && ulimit -n; echo 'done'", model.Spider{MaxOpenFiles: 64})
+			out, err := exec.Command("sh", "-c", cmdStr).Output()
+			So(err, ShouldBeNil)
+			So(strings.Fields(string(out)), ShouldResemble, []string{"64", "done"})
+		})
+		Convey("command is not run when the limit cannot be set", func() {
+			cmdStr := GetLimitedCmd("echo done", model.Spider{MaxOpenFiles: 1 << 40})
+			out, err := exec.Command("sh", "-c", cmdStr).Output()
+			So(err, ShouldNotBeNil)
+			So(string(out), ShouldEqual, "")
+		})
+	})
+}
+
+func TestHasCgroupLimits(t *testing.T) {
+	Convey("Test HasCgroupLimits", t, func() {
+		So(HasCgroupLimits(model.Spider{}), ShouldBeFalse)
+		So(HasCgroupLimits(model.Spider{MaxOpenFiles: 100}), ShouldBeFalse)
+		So(HasCgroupLimits(model.Spider{CpuLimit: 0.5}), ShouldBeTrue)
+		So(HasCgroupLimits(model.Spider{MemoryLimit: 512}), ShouldBeTrue)
+	})
+}
+
+func TestTaskCgroup(t *testing.T) {
+	Convey("Test TaskCgroup", t, func() {
+		dir, err := ioutil.TempDir("", "crawlab-cgroup")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		g := &TaskCgroup{Path: dir}
+
+		Convey("reads oom kills from memory.events", func() {
+			So(g.IsOomKilled(), ShouldBeFalse)
+			So(ioutil.WriteFile(filepath.Join(dir, "memory.events"), []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 0\n"), 0644), ShouldBeNil)
+			So(g.IsOomKilled(), ShouldBeFalse)
+			So(ioutil.WriteFile(filepath.Join(dir, "memory.events"), []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n"), 0644), ShouldBeNil)
+			So(g.IsOomKilled(), ShouldBeTrue)
+		})
+		Convey("process is created in the cgroup", func() {
+			cmd := exec.Command("true")
+			closeCgroup, err := g.Attach(cmd)
+			So(err, ShouldBeNil)
+			defer closeCgroup()
+			So(cmd.SysProcAttr.UseCgroupFD, ShouldBeTrue)
+			So(cmd.SysProcAttr.CgroupFD, ShouldBeGreaterThan, 0)
+		})
+		Convey("missing cgroup is an error", func() {
+			_, err := (&TaskCgroup{Path: filepath.Join(dir, "missing")}).Attach(exec.Command("true"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package services
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func attachCgroup(cmd *exec.Cmd, path string) (func(), error) {
+	return nil, errors.New("cgroup is not supported on this platform")
+}
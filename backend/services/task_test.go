@@ -0,0 +1,84 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestGetTaskQueueName(t *testing.T) {
+	Convey("Test GetTaskQueueName", t, func() {
+		Convey("public queue with default priority", func() {
+			task := model.Task{NodeId: bson.ObjectIdHex(constants.ObjectIdNull)}
+			So(GetTaskQueueName(task), ShouldEqual, "tasks:public:5")
+		})
+		Convey("node queue with given priority", func() {
+			nodeId := bson.NewObjectId()
+			task := model.Task{NodeId: nodeId, Priority: constants.TaskPriorityHighest}
+			So(GetTaskQueueName(task), ShouldEqual, "tasks:node:"+nodeId.Hex()+":1")
+		})
+		Convey("out of range priority falls back to default", func() {
+			So(GetTaskPriority(0), ShouldEqual, constants.TaskPriorityDefault)
+			So(GetTaskPriority(constants.TaskPriorityLowest+1), ShouldEqual, constants.TaskPriorityDefault)
+			So(GetTaskPriority(constants.TaskPriorityLowest), ShouldEqual, constants.TaskPriorityLowest)
+		})
+	})
+}
+
+func TestGetTaskQueueNames(t *testing.T) {
+	Convey("Test GetTaskQueueNames", t, func() {
+		nodeId := bson.NewObjectId()
+		queues := GetTaskQueueNames(nodeId)
+		So(len(queues), ShouldEqual, 2*constants.TaskPriorityLowest)
+		So(queues[0], ShouldEqual, "tasks:node:"+nodeId.Hex()+":1")
+		So(queues[1], ShouldEqual, "tasks:public:1")
+		So(queues[len(queues)-1], ShouldEqual, "tasks:public:10")
+	})
+}
+
+func TestGetTaskTimezone(t *testing.T) {
+	Convey("Test GetTaskTimezone", t, func() {
+		So(GetTaskTimezone(model.Task{Timezone: "Asia/Tokyo"}, model.Spider{Timezone: "UTC"}), ShouldEqual, "Asia/Tokyo")
+		So(GetTaskTimezone(model.Task{}, model.Spider{Timezone: "UTC"}), ShouldEqual, "UTC")
+	})
+}
+
+func TestAcquireNodeTaskSlot(t *testing.T) {
+	Convey("Test acquireNodeTaskSlot", t, func() {
+		nodeTaskCount.Store(0)
+		defer nodeTaskCount.Store(0)
+
+		So(acquireNodeTaskSlot(2), ShouldBeTrue)
+		So(acquireNodeTaskSlot(2), ShouldBeTrue)
+		So(acquireNodeTaskSlot(2), ShouldBeFalse)
+		nodeTaskCount.Dec()
+		So(acquireNodeTaskSlot(2), ShouldBeTrue)
+
+		Convey("no limit", func() {
+			So(acquireNodeTaskSlot(0), ShouldBeTrue)
+			So(nodeTaskCount.Load(), ShouldEqual, 3)
+		})
+	})
+}
+
+func TestGetTaskSlotBackoff(t *testing.T) {
+	Convey("Test GetTaskSlotBackoff", t, func() {
+		So(GetTaskSlotBackoff(0), ShouldEqual, time.Second)
+		So(GetTaskSlotBackoff(2), ShouldEqual, 4*time.Second)
+		So(GetTaskSlotBackoff(10), ShouldEqual, 30*time.Second)
+	})
+}
+
+func TestValidateTaskRunType(t *testing.T) {
+	Convey("Test ValidateTaskRunType", t, func() {
+		So(ValidateTaskRunType(constants.RunTypeAllNodes, nil), ShouldBeNil)
+		So(ValidateTaskRunType(constants.RunTypeRandom, nil), ShouldBeNil)
+		So(ValidateTaskRunType(constants.RunTypeSelectedNodes, []bson.ObjectId{bson.NewObjectId()}), ShouldBeNil)
+		So(ValidateTaskRunType(constants.RunTypeSelectedNodes, nil), ShouldNotBeNil)
+		So(ValidateTaskRunType(constants.RunTypeSelectedNodes, []bson.ObjectId{""}), ShouldNotBeNil)
+		So(ValidateTaskRunType("unknown", nil), ShouldNotBeNil)
+	})
+}
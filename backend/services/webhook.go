@@ -0,0 +1,256 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/database"
+	"crawlab/model"
+	"crawlab/utils"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/globalsign/mgo/bson"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Web Hook 签名请求头，签名为 utils.ComputeHmacSha256(时间戳 + "." + 请求体, 密钥)
+const (
+	WebhookSignatureHeader = "X-Crawlab-Signature"
+	WebhookTimestampHeader = "X-Crawlab-Timestamp"
+)
+
+// 请求时间戳允许的误差，超过后拒绝请求，避免重放
+const webhookTimestampTolerance = 5 * time.Minute
+
+// 请求体最大长度
+const MaxWebhookPayloadSize = 1 << 20
+
+// 单个环境变量值的最大长度（Linux 限制单个环境变量不超过 128KB，超过后无法启动任务）
+const MaxWebhookEnvSize = 128<<10 - 1024
+
+// 参数模版中的字段，如 {{data.id}}
+var webhookParamPattern = regexp.MustCompile(`{{\s*([^{}\s]+)\s*}}`)
+
+// 生成 Web Hook 密钥
+func GenerateWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// 计算请求签名
+func ComputeWebhookSignature(secret string, timestamp string, body []byte) string {
+	return utils.ComputeHmacSha256(timestamp+"."+string(body), secret)
+}
+
+// 校验请求时间戳和签名
+func VerifyWebhookSignature(w model.Webhook, timestamp string, signature string, body []byte, now time.Time) error {
+	if timestamp == "" || signature == "" {
+		return errors.New("missing signature")
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	diff := now.Sub(time.Unix(ts, 0))
+	if diff > webhookTimestampTolerance || diff < -webhookTimestampTolerance {
+		return errors.New("timestamp is out of range")
+	}
+	expected := ComputeWebhookSignature(w.Secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// 记录已处理的请求签名，时间戳误差范围内重复的请求视为重放
+func CheckWebhookReplay(w model.Webhook, signature string) error {
+	key := fmt.Sprintf("webhook:signature:%s:%s", w.Id.Hex(), signature)
+	ok, err := database.RedisClient.SetNX(key, "1", 2*webhookTimestampTolerance)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("duplicate request")
+	}
+	return nil
+}
+
+// 校验 Web Hook
+func ValidateWebhook(w model.Webhook) error {
+	if w.SpiderId.Valid() == w.ScheduleId.Valid() {
+		return errors.New("either spider_id or schedule_id should be set")
+	}
+	if w.SpiderId.Valid() {
+		if _, err := model.GetSpider(w.SpiderId); err != nil {
+			return errors.New(fmt.Sprintf("spider not found: %s", w.SpiderId.Hex()))
+		}
+		if err := ValidateTaskRunType(w.RunType, w.NodeIds); err != nil {
+			return err
+		}
+	}
+	if w.ScheduleId.Valid() {
+		if _, err := model.GetSchedule(w.ScheduleId); err != nil {
+			return errors.New(fmt.Sprintf("schedule not found: %s", w.ScheduleId.Hex()))
+		}
+	}
+	for _, env := range w.Envs {
+		if env.Name == "" || env.Path == "" {
+			return errors.New("env name and path should not be empty")
+		}
+	}
+	return nil
+}
+
+// 解析请求数据，请求体为空时返回 nil
+func ParseWebhookPayload(body []byte) (interface{}, error) {
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return nil, nil
+	}
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, errors.New("invalid json payload")
+	}
+	return payload, nil
+}
+
+// 按路径（以 . 分隔，数组使用下标）获取请求数据中的字段，非字符串的值转换为 JSON
+func GetWebhookPayloadValue(payload interface{}, path string) (string, bool) {
+	value := payload
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			item, ok := v[key]
+			if !ok {
+				return "", false
+			}
+			value = item
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return "", false
+			}
+			value = v[index]
+		default:
+			return "", false
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(b), true
+	}
+}
+
+// 用请求数据渲染参数模版，字段值经过 shell 转义，不存在的字段替换为空字符串
+func RenderWebhookParam(tpl string, payload interface{}) string {
+	return webhookParamPattern.ReplaceAllStringFunc(tpl, func(match string) string {
+		path := webhookParamPattern.FindStringSubmatch(match)[1]
+		value, _ := GetWebhookPayloadValue(payload, path)
+		return utils.ShellQuote(value)
+	})
+}
+
+// 任务的环境变量：Web Hook ID、原始请求数据及映射的字段
+// 超过 MaxWebhookEnvSize 的值不设置，原始请求数据的长度由 CRAWLAB_WEBHOOK_PAYLOAD_SIZE 给出
+func GetWebhookEnvs(w model.Webhook, payload interface{}, body []byte) []model.Env {
+	envs := []model.Env{
+		{Name: "CRAWLAB_WEBHOOK_ID", Value: w.Id.Hex()},
+		{Name: "CRAWLAB_WEBHOOK_PAYLOAD", Value: limitWebhookEnvValue(string(body))},
+		{Name: "CRAWLAB_WEBHOOK_PAYLOAD_SIZE", Value: strconv.Itoa(len(body))},
+	}
+	for _, env := range w.Envs {
+		value, _ := GetWebhookPayloadValue(payload, env.Path)
+		envs = append(envs, model.Env{Name: env.Name, Value: limitWebhookEnvValue(value)})
+	}
+	return envs
+}
+
+func limitWebhookEnvValue(value string) string {
+	if len(value) > MaxWebhookEnvSize {
+		return ""
+	}
+	return value
+}
+
+// 触发 Web Hook，请求数据映射到任务的参数和环境变量，返回创建的任务ID
+func TriggerWebhook(w model.Webhook, body []byte) ([]string, error) {
+	payload, err := ParseWebhookPayload(body)
+	if err != nil {
+		return nil, err
+	}
+
+	t := model.Task{
+		UserId:     w.UserId,
+		ScheduleId: bson.ObjectIdHex(constants.ObjectIdNull),
+		Type:       constants.TaskTypeSpider,
+		Envs:       GetWebhookEnvs(w, payload, body),
+		Trigger:    constants.TaskTriggerWebhook,
+		WebhookId:  w.Id,
+	}
+
+	var taskIds []string
+	if w.ScheduleId.Valid() {
+		// 使用定时任务的运行配置
+		s, err := model.GetSchedule(w.ScheduleId)
+		if err != nil {
+			return nil, err
+		}
+		spider, err := model.GetSpider(s.SpiderId)
+		if err != nil {
+			return nil, err
+		}
+		param := s.Param
+		if w.Param != "" {
+			param = RenderWebhookParam(w.Param, payload)
+		}
+		if spider.IsScrapy {
+			if s.ScrapySpider == "" {
+				return nil, errors.New("scrapy spider is not set")
+			}
+			param = s.ScrapySpider + " -L " + s.ScrapyLogLevel + " " + param
+		}
+
+		t.SpiderId = s.SpiderId
+		t.Param = param
+		t.ScheduleId = s.Id
+		t.Priority = s.Priority
+		t.Timeout = s.Timeout
+		t.Timezone = s.Timezone
+		taskIds, err = AddTasksByRunType(t, s.RunType, s.NodeIds)
+		if err != nil {
+			return taskIds, err
+		}
+	} else {
+		t.SpiderId = w.SpiderId
+		t.Param = RenderWebhookParam(w.Param, payload)
+		t.Priority = w.Priority
+		t.Timeout = w.Timeout
+		taskIds, err = AddTasksByRunType(t, w.RunType, w.NodeIds)
+		if err != nil {
+			return taskIds, err
+		}
+	}
+
+	_ = model.UpdateWebhookLastTriggerTs(w.Id, time.Now())
+	return taskIds, nil
+}
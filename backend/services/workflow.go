@@ -0,0 +1,218 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo/bson"
+	"runtime/debug"
+	"strings"
+)
+
+// 校验工作流：节点标识唯一、依赖指向已有节点、触发条件合法、无环
+func ValidateWorkflow(w model.Workflow) error {
+	if len(w.Nodes) == 0 {
+		return errors.New("workflow has no nodes")
+	}
+
+	// 节点
+	keys := map[string]bool{}
+	for _, n := range w.Nodes {
+		if n.Key == "" {
+			return errors.New("workflow node key is empty")
+		}
+		if keys[n.Key] {
+			return errors.New(fmt.Sprintf("duplicated workflow node key: %s", n.Key))
+		}
+		if !n.SpiderId.Valid() {
+			return errors.New(fmt.Sprintf("invalid spider id of workflow node: %s", n.Key))
+		}
+		keys[n.Key] = true
+	}
+
+	// 依赖
+	for _, e := range w.Edges {
+		if !keys[e.Source] || !keys[e.Target] {
+			return errors.New(fmt.Sprintf("workflow edge refers to unknown node: %s -> %s", e.Source, e.Target))
+		}
+		if e.Source == e.Target {
+			return errors.New(fmt.Sprintf("workflow edge refers to itself: %s", e.Source))
+		}
+		switch e.Condition {
+		case "", constants.WorkflowConditionOnSuccess, constants.WorkflowConditionOnFailure, constants.WorkflowConditionAlways:
+		default:
+			return errors.New(fmt.Sprintf("invalid workflow edge condition: %s", e.Condition))
+		}
+	}
+
+	// 拓扑排序检查环
+	inDegree := map[string]int{}
+	for _, e := range w.Edges {
+		inDegree[e.Target]++
+	}
+	var queue []string
+	for _, n := range w.Nodes {
+		if inDegree[n.Key] == 0 {
+			queue = append(queue, n.Key)
+		}
+	}
+	visited := 0
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+		visited++
+		for _, e := range w.GetOutgoingEdges(key) {
+			inDegree[e.Target]--
+			if inDegree[e.Target] == 0 {
+				queue = append(queue, e.Target)
+			}
+		}
+	}
+	if visited != len(w.Nodes) {
+		return errors.New("workflow contains a cycle")
+	}
+
+	return nil
+}
+
+// 上游任务是否满足依赖条件
+func IsWorkflowConditionMet(condition string, status string) bool {
+	switch condition {
+	case constants.WorkflowConditionAlways:
+		return true
+	case constants.WorkflowConditionOnFailure:
+		return status != constants.StatusFinished
+	default:
+		return status == constants.StatusFinished
+	}
+}
+
+// 运行工作流，从根节点开始
+func RunWorkflow(id bson.ObjectId, uid bson.ObjectId) (bson.ObjectId, error) {
+	w, err := model.GetWorkflow(id)
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateWorkflow(w); err != nil {
+		return "", err
+	}
+
+	// 运行记录
+	run := model.WorkflowRun{
+		WorkflowId: w.Id,
+		UserId:     uid,
+	}
+	if err := model.AddWorkflowRun(&run); err != nil {
+		return "", err
+	}
+
+	// 根节点任务
+	for _, n := range w.GetRootNodes() {
+		if _, err := AddWorkflowTask(w, run, n, nil); err != nil {
+			return run.Id, err
+		}
+	}
+
+	return run.Id, nil
+}
+
+// 生成定时运行工作流方法
+func RunWorkflowFunc(w model.Workflow) func() {
+	return func() {
+		if _, err := RunWorkflow(w.Id, w.UserId); err != nil {
+			log.Errorf("run workflow error: %s, workflow: %s", err.Error(), w.Name)
+			debug.PrintStack()
+		}
+	}
+}
+
+// 创建工作流节点的任务
+func AddWorkflowTask(w model.Workflow, run model.WorkflowRun, n model.WorkflowNode, upstreamTaskIds []string) (string, error) {
+	// 同一运行记录中每个节点只触发一次
+	ok, err := model.ClaimWorkflowRunNode(run.Id, n.Key)
+	if err != nil || !ok {
+		return "", err
+	}
+
+	t := model.Task{
+		SpiderId:        n.SpiderId,
+		NodeId:          n.NodeId,
+		Param:           n.Param,
+		UserId:          run.UserId,
+		RunType:         constants.RunTypeSelectedNodes,
+		ScheduleId:      bson.ObjectIdHex(constants.ObjectIdNull),
+		Type:            constants.TaskTypeSpider,
+		WorkflowId:      w.Id,
+		WorkflowRunId:   run.Id,
+		WorkflowNodeKey: n.Key,
+		UpstreamTaskId:  strings.Join(upstreamTaskIds, ","),
+	}
+	if !n.NodeId.Valid() || n.NodeId.Hex() == constants.ObjectIdNull {
+		t.NodeId = bson.ObjectIdHex(constants.ObjectIdNull)
+		t.RunType = constants.RunTypeRandom
+	}
+
+	return AddTask(t)
+}
+
+// 任务结束后触发工作流下游任务，下游节点在其所有上游都结束且满足条件后触发
+func TriggerWorkflowDownstream(t model.Task) {
+	if !t.WorkflowId.Valid() || !t.WorkflowRunId.Valid() {
+		return
+	}
+
+	w, err := model.GetWorkflow(t.WorkflowId)
+	if err != nil {
+		return
+	}
+	run := model.WorkflowRun{
+		Id:         t.WorkflowRunId,
+		WorkflowId: w.Id,
+		UserId:     t.UserId,
+	}
+
+	for _, edge := range w.GetOutgoingEdges(t.WorkflowNodeKey) {
+		n, ok := w.GetNode(edge.Target)
+		if !ok {
+			continue
+		}
+
+		// 检查所有上游任务
+		ready := true
+		var upstreamTaskIds []string
+		for _, in := range w.GetIncomingEdges(n.Key) {
+			upstream, err := model.GetLatestWorkflowTask(run.Id, in.Source)
+			if err != nil || !IsWorkflowTaskFinal(upstream) {
+				ready = false
+				break
+			}
+			if !IsWorkflowConditionMet(in.Condition, upstream.Status) {
+				ready = false
+				break
+			}
+			upstreamTaskIds = append(upstreamTaskIds, upstream.Id)
+		}
+		if !ready {
+			continue
+		}
+
+		if _, err := AddWorkflowTask(w, run, n, upstreamTaskIds); err != nil {
+			log.Errorf("add workflow task error: %s, workflow: %s, node: %s", err.Error(), w.Name, n.Key)
+			debug.PrintStack()
+		}
+	}
+}
+
+// 工作流任务是否已结束（不会再重试）
+func IsWorkflowTaskFinal(t model.Task) bool {
+	if t.Status == constants.StatusPending || t.Status == constants.StatusRunning {
+		return false
+	}
+	spider, err := t.GetSpider()
+	if err != nil {
+		return true
+	}
+	return !ShouldRetryTask(t, spider)
+}
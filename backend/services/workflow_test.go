@@ -0,0 +1,56 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestValidateWorkflow(t *testing.T) {
+	Convey("Test ValidateWorkflow", t, func() {
+		w := model.Workflow{
+			Nodes: []model.WorkflowNode{
+				{Key: "a", SpiderId: bson.NewObjectId()},
+				{Key: "b", SpiderId: bson.NewObjectId()},
+				{Key: "c", SpiderId: bson.NewObjectId()},
+			},
+			Edges: []model.WorkflowEdge{
+				{Source: "a", Target: "b", Condition: constants.WorkflowConditionOnSuccess},
+				{Source: "b", Target: "c", Condition: constants.WorkflowConditionAlways},
+			},
+		}
+
+		Convey("valid workflow", func() {
+			So(ValidateWorkflow(w), ShouldBeNil)
+			So(len(w.GetRootNodes()), ShouldEqual, 1)
+		})
+		Convey("cycle is rejected", func() {
+			w.Edges = append(w.Edges, model.WorkflowEdge{Source: "c", Target: "a"})
+			So(ValidateWorkflow(w), ShouldNotBeNil)
+		})
+		Convey("unknown node is rejected", func() {
+			w.Edges = append(w.Edges, model.WorkflowEdge{Source: "c", Target: "d"})
+			So(ValidateWorkflow(w), ShouldNotBeNil)
+		})
+		Convey("duplicated key is rejected", func() {
+			w.Nodes = append(w.Nodes, model.WorkflowNode{Key: "a", SpiderId: bson.NewObjectId()})
+			So(ValidateWorkflow(w), ShouldNotBeNil)
+		})
+		Convey("invalid condition is rejected", func() {
+			w.Edges[0].Condition = "sometimes"
+			So(ValidateWorkflow(w), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestIsWorkflowConditionMet(t *testing.T) {
+	Convey("Test IsWorkflowConditionMet", t, func() {
+		So(IsWorkflowConditionMet(constants.WorkflowConditionOnSuccess, constants.StatusFinished), ShouldBeTrue)
+		So(IsWorkflowConditionMet("", constants.StatusError), ShouldBeFalse)
+		So(IsWorkflowConditionMet(constants.WorkflowConditionOnFailure, constants.StatusError), ShouldBeTrue)
+		So(IsWorkflowConditionMet(constants.WorkflowConditionOnFailure, constants.StatusFinished), ShouldBeFalse)
+		So(IsWorkflowConditionMet(constants.WorkflowConditionAlways, constants.StatusCancelled), ShouldBeTrue)
+	})
+}
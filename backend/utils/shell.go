@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+var shellSafeRegex = regexp.MustCompile(`^[\w@%+=:,./-]+$`)
+
+// 转义为 sh 中的单个参数，只含安全字符时原样返回
+func ShellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if shellSafeRegex.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"os/exec"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	Convey("Test ShellQuote", t, func() {
+		So(ShellQuote("phone"), ShouldEqual, "phone")
+		So(ShellQuote("http://example.com/a?b=1"), ShouldEqual, "'http://example.com/a?b=1'")
+		So(ShellQuote(""), ShouldEqual, "''")
+		So(ShellQuote("it's"), ShouldEqual, `'it'"'"'s'`)
+
+		Convey("values are passed to sh as single arguments", func() {
+			for _, value := range []string{"$(echo evil)", "`id`; rm -rf /", "it's", "a b\nc", "$HOME"} {
+				out, err := exec.Command("sh", "-c", "printf %s "+ShellQuote(value)).Output()
+				So(err, ShouldBeNil)
+				So(string(out), ShouldEqual, value)
+			}
+		})
+	})
+}